@@ -6,13 +6,19 @@ package gostlink
 
 import (
 	"errors"
-
-	"github.com/boljen/go-bitmap"
 )
 
-var (
-	openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
-)
+// OpenAccessPort initializes the access port apsel on the st-link, AP 0 is
+// opened when entering debug mode. Other access ports require V2J28/V3 firmware.
+func (h *StLink) OpenAccessPort(apsel uint16) error {
+	if apsel != 0 {
+		if err := h.RequireFeature("ap-init"); err != nil {
+			return err
+		}
+	}
+
+	return h.usbOpenAccessPort(apsel)
+}
 
 func (h *StLink) usbOpenAccessPort(apsel uint16) error {
 
@@ -25,7 +31,7 @@ func (h *StLink) usbOpenAccessPort(apsel uint16) error {
 		return errors.New("apsel > DP_APSEL_MAX")
 	}
 
-	if openedAp.Get(int(apsel)) {
+	if h.openedAp.Get(int(apsel)) {
 		return nil
 	}
 
@@ -36,7 +42,7 @@ func (h *StLink) usbOpenAccessPort(apsel uint16) error {
 	}
 
 	logger.Debugf("Access port %d enabled", apsel)
-	openedAp.Set(int(apsel), true)
+	h.openedAp.Set(int(apsel), true)
 	return nil
 }
 
@@ -62,3 +68,114 @@ func (h *StLink) usbInitAccessPort(apNum byte) error {
 		return nil
 	}
 }
+
+const (
+	debugPortAccess = 0xffff // port number selecting the debug port in dap register access
+
+	apBaseRegister = 0xF8
+	apIdrRegister  = 0xFC
+
+	apIdrClassMask   = 0xf << 13
+	apIdrClassMemAp  = 0x8 << 13
+	multiCoreApCount = 8 // access ports probed by IsMultiCore, stm32 parts use the lowest numbers
+
+	apBaseFormatAdiV5 = 1 << 1
+	apBasePresent     = 1 << 0
+	apBaseLegacyNone  = 0xffffffff
+)
+
+// ReadDapRegister reads the register at addr of access port port, port 0xffff
+// selects the debug port. Requires V2J24 firmware.
+func (h *StLink) ReadDapRegister(port uint16, addr uint32) (uint32, error) {
+	if err := h.RequireFeature("dap-reg"); err != nil {
+		return 0, err
+	}
+
+	if port != debugPortAccess {
+		if err := h.usbOpenAccessPort(port); err != nil {
+			return 0, err
+		}
+	}
+
+	ctx := h.initTransfer(transferIncoming)
+
+	ctx.cmdBuf.WriteByte(cmdDebug)
+	ctx.cmdBuf.WriteByte(debugApiV2ReadDebugAccessPortRegister)
+	ctx.cmdBuf.WriteUint16LE(port)
+	ctx.cmdBuf.WriteUint16LE(uint16(addr))
+
+	if err := h.usbTransferErrCheck(ctx, 8); err != nil {
+		return 0, err
+	}
+
+	return tryConvertToUint32(ctx.DataBytes()[4:], littleEndian)
+}
+
+// RomTableBase returns the address of the rom table from the BASE register of
+// the memory access port (AP 0)
+func (h *StLink) RomTableBase() (uint32, error) {
+	base, err := h.ReadDapRegister(0, apBaseRegister)
+
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Debugf("AP 0 BASE: %08x", base)
+
+	if base == apBaseLegacyNone || ((base&apBaseFormatAdiV5) > 0 && (base&apBasePresent) == 0) {
+		return 0, errors.New("no rom table present on access port")
+	}
+
+	return base & 0xfffff000, nil
+}
+
+// IsMultiCore probes the access ports 0-7 and returns the ones responding. The
+// target counts as multi core if more than one of them is a memory access port
+// (e.g. STM32H745, STM32WB), which also holds for parts with an additional
+// system bus access port. Without V2J24 firmware the AP type is not checked.
+func (h *StLink) IsMultiCore() (bool, []uint16, error) {
+	if err := h.RequireFeature("ap-init"); err != nil {
+		return false, nil, err
+	}
+
+	var ports []uint16
+	memAps := 0
+
+	for apsel := uint16(0); apsel < multiCoreApCount; apsel++ {
+		if err := h.usbOpenAccessPort(apsel); err != nil {
+			if errors.Is(err, ErrProbeDisconnected) {
+				return false, nil, err
+			}
+
+			logger.Debugf("access port %d not present: %s", apsel, err)
+			continue
+		}
+
+		if !h.version.flags.Get(flagHasDapReg) {
+			ports = append(ports, apsel)
+			memAps++
+			continue
+		}
+
+		idr, err := h.ReadDapRegister(apsel, apIdrRegister)
+
+		if errors.Is(err, ErrProbeDisconnected) {
+			return false, nil, err
+		}
+
+		if err != nil || idr == 0 {
+			logger.Debugf("access port %d not present (IDR %08x)", apsel, idr)
+			continue
+		}
+
+		logger.Debugf("access port %d IDR: %08x", apsel, idr)
+
+		ports = append(ports, apsel)
+
+		if (idr & apIdrClassMask) == apIdrClassMemAp {
+			memAps++
+		}
+	}
+
+	return memAps > 1, ports, nil
+}
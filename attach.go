@@ -0,0 +1,60 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Attach initializes usb, opens the st-link described by config and verifies
+// that a powered target answers with a valid id code. Release the returned
+// handle with Shutdown.
+func Attach(config *StLinkInterfaceConfig) (*StLink, error) {
+	if err := InitUsb(); err != nil {
+		return nil, fmt.Errorf("could not initialize usb: %w", err)
+	}
+
+	handle, err := NewStLink(config)
+
+	if err != nil {
+		CloseUSB()
+		return nil, fmt.Errorf("could not open st-link: %w", err)
+	}
+
+	if handle.version.flags.Get(flagHasTargetVolt) {
+		voltage, err := handle.GetTargetVoltage()
+
+		if err != nil {
+			handle.Shutdown()
+			return nil, fmt.Errorf("could not measure target voltage: %w", err)
+		}
+
+		if voltage < minimumTargetVoltage {
+			handle.Shutdown()
+			return nil, fmt.Errorf("target voltage %.2fV too low, is the target powered?", voltage)
+		}
+	} else {
+		logger.Debug("st-link does not support voltage measurement, skip voltage check")
+	}
+
+	if handle.stMode != StLinkModeDebugSwim {
+		idCode, err := handle.GetIdCode()
+
+		if err != nil {
+			handle.Shutdown()
+			return nil, fmt.Errorf("could not read target id code: %w", err)
+		}
+
+		if idCode == 0 {
+			handle.Shutdown()
+			return nil, errors.New("no target answered on debug port (id code is zero)")
+		}
+
+		logger.Infof("attached to target with id code %08x", idCode)
+	}
+
+	return handle, nil
+}
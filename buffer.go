@@ -6,6 +6,7 @@ package gostlink
 
 import (
 	"bytes"
+	"fmt"
 	"math"
 )
 
@@ -20,6 +21,12 @@ const (
 	bigEndian           = 1
 )
 
+// byte order of the target memory, little endian for all cortex-m devices
+const (
+	TargetLittleEndian Endian = littleEndian
+	TargetBigEndian    Endian = bigEndian
+)
+
 func (e Endian) toString() string {
 	if e == littleEndian {
 		return "little endian"
@@ -36,6 +43,18 @@ func NewBuffer(initSize int) *Buffer {
 	return b
 }
 
+// Zero clears the buffered bytes before emptying the buffer, so a reused
+// buffer never exposes data of a previous transfer
+func (buf *Buffer) Zero() {
+	data := buf.Bytes()
+
+	for i := range data {
+		data[i] = 0
+	}
+
+	buf.Reset()
+}
+
 func (buf *Buffer) WriteUint32LE(value uint32) {
 	buf.WriteByte(byte(value))
 	buf.WriteByte(byte(value >> 8))
@@ -43,6 +62,21 @@ func (buf *Buffer) WriteUint32LE(value uint32) {
 	buf.WriteByte(byte(value >> 24))
 }
 
+func (buf *Buffer) WriteUint32BE(value uint32) {
+	buf.WriteByte(byte(value >> 24))
+	buf.WriteByte(byte(value >> 16))
+	buf.WriteByte(byte(value >> 8))
+	buf.WriteByte(byte(value))
+}
+
+func (buf *Buffer) WriteUint32(value uint32, e Endian) {
+	if e == littleEndian {
+		buf.WriteUint32LE(value)
+	} else {
+		buf.WriteUint32BE(value)
+	}
+}
+
 func (buf *Buffer) WriteUint16LE(value uint16) {
 	buf.WriteByte(byte(value))
 	buf.WriteByte(byte(value >> 8))
@@ -64,30 +98,64 @@ func (buf *Buffer) ReadUint32LE() uint32 {
 	return convertToUint32(buf.Bytes(), littleEndian)
 }
 
-func convertToUint16(buf []byte, e Endian) uint16 {
-	if len(buf) > 1 {
+func (buf *Buffer) ReadUint32Slice(offset int, count int, e Endian) ([]uint32, error) {
+	data := buf.Bytes()
+
+	if offset < 0 || count < 0 || offset+count*4 > len(data) {
+		return nil, fmt.Errorf("could not read %d uint32 at offset %d from buffer of %d bytes", count, offset, len(data))
+	}
+
+	values := make([]uint32, count)
 
-		if e == littleEndian {
-			return uint16(buf[0]) | (uint16(buf[1]) << 8)
-		} else {
-			return uint16(buf[1]) | (uint16(buf[0]) << 8)
-		}
+	for i := range values {
+		values[i], _ = tryConvertToUint32(data[offset+i*4:], e)
+	}
+
+	return values, nil
+}
+
+func tryConvertToUint16(buf []byte, e Endian) (uint16, error) {
+	if len(buf) < 2 {
+		return 0, fmt.Errorf("could not read uint16 %s from buffer of %d bytes", e.toString(), len(buf))
+	}
+
+	if e == littleEndian {
+		return uint16(buf[0]) | (uint16(buf[1]) << 8), nil
+	} else {
+		return uint16(buf[1]) | (uint16(buf[0]) << 8), nil
+	}
+}
+
+func tryConvertToUint32(buf []byte, e Endian) (uint32, error) {
+	if len(buf) < 4 {
+		return 0, fmt.Errorf("could not read uint32 %s from buffer of %d bytes", e.toString(), len(buf))
+	}
+
+	if e == littleEndian {
+		return uint32(buf[0]) | (uint32(buf[1]) << 8) | (uint32(buf[2]) << 16) | (uint32(buf[3]) << 24), nil
 	} else {
-		logger.Errorf("could not read uint16 %s from given buffer", e.toString())
+		return uint32(buf[3]) | (uint32(buf[2]) << 8) | (uint32(buf[1]) << 16) | (uint32(buf[0]) << 24), nil
+	}
+}
+
+func convertToUint16(buf []byte, e Endian) uint16 {
+	value, err := tryConvertToUint16(buf, e)
+
+	if err != nil {
+		logger.Error(err)
 		return math.MaxUint16
 	}
+
+	return value
 }
 
 func convertToUint32(buf []byte, e Endian) uint32 {
-	if len(buf) > 3 {
+	value, err := tryConvertToUint32(buf, e)
 
-		if e == littleEndian {
-			return uint32(buf[0]) | (uint32(buf[1]) << 8) | (uint32(buf[2]) << 16) | (uint32(buf[3]) << 24)
-		} else {
-			return uint32(buf[3]) | (uint32(buf[2]) << 8) | (uint32(buf[1]) << 16) | (uint32(buf[0]) << 24)
-		}
-	} else {
-		logger.Errorf("could not read uint32 %s from given buffer", e.toString())
+	if err != nil {
+		logger.Error(err)
 		return math.MaxUint32
 	}
+
+	return value
 }
@@ -0,0 +1,83 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import "testing"
+
+func TestBufferZero(t *testing.T) {
+	buf := NewBuffer(8)
+	buf.WriteUint32LE(0xdeadbeef)
+
+	data := buf.Bytes()
+	buf.Zero()
+
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left after Zero", buf.Len())
+	}
+
+	for i, b := range data {
+		if b != 0 {
+			t.Errorf("byte %d = 0x%02x after Zero", i, b)
+		}
+	}
+
+	// the zeroed storage is reused by the next write
+	buf.WriteByte(0x11)
+
+	if data[0] != 0x11 || data[1] != 0 {
+		t.Errorf("reused buffer holds % x", data)
+	}
+}
+
+func TestBufferReadUint32Slice(t *testing.T) {
+	values := []uint32{0x01020304, 0xdeadbeef, 0}
+
+	for _, e := range []Endian{littleEndian, bigEndian} {
+		buf := NewBuffer(16)
+		buf.WriteByte(0xff) // offset the words by one byte
+
+		for _, v := range values {
+			buf.WriteUint32(v, e)
+		}
+
+		read, err := buf.ReadUint32Slice(1, len(values), e)
+
+		if err != nil {
+			t.Fatalf("%s: %v", e.toString(), err)
+		}
+
+		for i := range values {
+			if read[i] != values[i] {
+				t.Errorf("%s: value %d = 0x%08x, want 0x%08x", e.toString(), i, read[i], values[i])
+			}
+		}
+	}
+}
+
+func TestBufferReadUint32SliceShort(t *testing.T) {
+	buf := NewBuffer(8)
+	buf.WriteUint32LE(1)
+	buf.WriteUint16LE(2)
+
+	if _, err := buf.ReadUint32Slice(0, 2, littleEndian); err == nil {
+		t.Error("read 2 words from a 6 byte buffer")
+	}
+
+	if _, err := buf.ReadUint32Slice(2, 1, littleEndian); err != nil {
+		t.Errorf("read of the last complete word failed: %v", err)
+	}
+
+	if _, err := buf.ReadUint32Slice(3, 1, littleEndian); err == nil {
+		t.Error("read of a word crossing the end succeeded")
+	}
+
+	if _, err := buf.ReadUint32Slice(-1, 1, littleEndian); err == nil {
+		t.Error("read at negative offset succeeded")
+	}
+
+	if values, err := buf.ReadUint32Slice(6, 0, littleEndian); err != nil || len(values) != 0 {
+		t.Errorf("empty read returned %v, %v", values, err)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// cortex-m7 cache maintenance registers
+const (
+	ccrRegister     = 0xE000ED14
+	ccsidrRegister  = 0xE000ED80
+	csselrRegister  = 0xE000ED84
+	dccswRegister   = 0xE000EF6C // clean data cache line by set/way
+	dccciswRegister = 0xE000EF74 // clean and invalidate data cache line by set/way
+
+	ccrDCacheEnable = 1 << 16
+
+	cpuIdPartNoCortexM7 = 0xc27
+)
+
+// CleanDCache writes dirty lines of the data cache back to memory, so memory
+// reads of the debugger see the data written by the core. It does nothing on
+// cores other than cortex-m7 or if the data cache is disabled.
+func (h *StLink) CleanDCache() error {
+	return h.dCacheMaintenance(dccswRegister)
+}
+
+// InvalidateDCache invalidates the data cache, so the core sees memory written
+// by the debugger. Dirty lines are written back before, data written by the
+// core is never discarded. It does nothing on cores other than cortex-m7 or if
+// the data cache is disabled.
+func (h *StLink) InvalidateDCache() error {
+	return h.dCacheMaintenance(dccciswRegister)
+}
+
+// runs the set/way operation register on every line of the l1 data cache, this
+// takes one usb transfer per line
+func (h *StLink) dCacheMaintenance(operation uint32) error {
+	cpuId, err := h.CpuId()
+
+	if err != nil {
+		return err
+	}
+
+	if cpuId.PartNo != cpuIdPartNoCortexM7 {
+		logger.Tracef("no data cache on core with part no %03x", cpuId.PartNo)
+		return nil
+	}
+
+	ccr, err := h.readUint32(ccrRegister)
+
+	if err != nil {
+		return err
+	}
+
+	if (ccr & ccrDCacheEnable) == 0 {
+		logger.Trace("data cache disabled, skipping cache maintenance")
+		return nil
+	}
+
+	// select the l1 data cache
+	if err = h.writeUint32(csselrRegister, 0); err != nil {
+		return err
+	}
+
+	ccsidr, err := h.readUint32(ccsidrRegister)
+
+	if err != nil {
+		return err
+	}
+
+	sets := (ccsidr >> 13) & 0x7fff
+	ways := (ccsidr >> 3) & 0x3ff
+
+	logger.Debugf("data cache maintenance on %d sets with %d ways", sets+1, ways+1)
+
+	for set := uint32(0); set <= sets; set++ {
+		for way := uint32(0); way <= ways; way++ {
+			if err = h.writeUint32(operation, (way<<30)|(set<<5)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+)
+
+const featureUnsupported = -1
+
+type stLinkCapability struct {
+	name string
+	flag int
+
+	minV2Jtag int // first ST-Link/V2 firmware version providing the feature
+	minV3Jtag int // first STLINK-V3 firmware version providing the feature
+}
+
+var stLinkCapabilities = []stLinkCapability{
+	{"trace", flagHasTrace, 13, 0},
+	{"target-voltage", flagHasTargetVolt, 13, 0},
+	{"swd-set-freq", flagHasSwdSetFreq, 22, featureUnsupported},
+	{"jtag-set-freq", flagHasJtagSetFreq, 24, featureUnsupported},
+	{"mem-16bit", flagHasMem16Bit, 26, 0},
+	{"last-rw-status2", flagHasGetLastRwStatus2, 15, 0},
+	{"dap-reg", flagHasDapReg, 24, 0},
+	{"quirk-jtag-dp-read", flagQuirkJtagDpRead, 24, featureUnsupported},
+	{"ap-init", flagHasApInit, 28, 0},
+	{"dp-bank-sel", flagHasDpBankSel, 32, 2},
+	{"rw8-512-bytes", flagHasRw8Bytes512, featureUnsupported, 6},
+	{"fix-close-ap", flagFixCloseAp, 29, 0},
+	{"multi-ap-mem", flagHasCsw, 32, 2},
+}
+
+// Capabilities returns the features reported by the firmware of the st-link
+func (h *StLink) Capabilities() map[string]bool {
+	capabilities := make(map[string]bool, len(stLinkCapabilities))
+
+	for _, c := range stLinkCapabilities {
+		capabilities[c.name] = h.version.flags.Get(c.flag)
+	}
+
+	return capabilities
+}
+
+// RequireFeature returns an error naming the required firmware version if the
+// st-link does not provide the feature (see Capabilities for feature names)
+func (h *StLink) RequireFeature(feature string) error {
+	for _, c := range stLinkCapabilities {
+		if c.name != feature {
+			continue
+		}
+
+		if h.version.flags.Get(c.flag) {
+			return nil
+		}
+
+		minJtag := featureUnsupported
+
+		switch h.version.stlink {
+		case 2:
+			minJtag = c.minV2Jtag
+		case 3:
+			minJtag = c.minV3Jtag
+		}
+
+		if minJtag == featureUnsupported {
+			return fmt.Errorf("%s is not supported by ST-Link V%d", feature, h.version.stlink)
+		}
+
+		return fmt.Errorf("%s requires ST-Link V%d firmware J%d or newer; yours is J%d",
+			feature, h.version.stlink, minJtag, h.version.jtag)
+	}
+
+	return fmt.Errorf("unknown st-link feature %s", feature)
+}
@@ -0,0 +1,496 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// returned by the rcc decoders for clock trees they can not follow, the trace
+// clock is measured with the cycle counter instead
+var errClockNotDecoded = errors.New("clock configuration not decoded")
+
+// rcc base addresses
+const (
+	rccBaseAhb1 = 0x40023800 // STM32F2/F4/F7/L1
+	rccBaseAhb  = 0x40021000 // STM32F0/F1/F3/G0/G4/L0/L4
+	rccBaseWb   = 0x58000000 // STM32WB
+)
+
+// decodes the core clock (HCLK) of a family from its rcc registers
+type rccClockDecoder func(h *StLink, device Stm32Device, hseHz uint32) (uint32, error)
+
+var rccClockDecoders = map[Stm32Family]rccClockDecoder{
+	Stm32FamilyF0: rccClockF0F3,
+	Stm32FamilyF1: rccClockF1,
+	Stm32FamilyF2: rccClockF2F4F7,
+	Stm32FamilyF3: rccClockF0F3,
+	Stm32FamilyF4: rccClockF2F4F7,
+	Stm32FamilyF7: rccClockF2F4F7,
+	Stm32FamilyG0: rccClockG0,
+	Stm32FamilyG4: rccClockL4G4Wb,
+	Stm32FamilyL0: rccClockL0L1,
+	Stm32FamilyL1: rccClockL0L1,
+	Stm32FamilyL4: rccClockL4G4Wb,
+	Stm32FamilyWB: rccClockL4G4Wb,
+}
+
+// msi frequencies of the MSIRANGE values of STM32L4/WB
+var msiRangesL4 = []uint32{
+	100000, 200000, 400000, 800000, 1000000, 2000000, 4000000, 8000000,
+	16000000, 24000000, 32000000, 48000000,
+}
+
+// PLLMUL values of STM32L0/L1
+var pllMulL0L1 = []uint32{3, 4, 6, 8, 12, 16, 24, 32, 48}
+
+// RccClock reads the core clock (HCLK) of the detected stm32 device from its rcc
+// registers. hseHz is the frequency of the external oscillator, it is only
+// needed if the clock tree is fed by the HSE.
+func (h *StLink) RccClock(hseHz uint32) (uint32, error) {
+	device, err := h.DetectDevice()
+
+	if err != nil {
+		return 0, err
+	}
+
+	decoder, ok := rccClockDecoders[device.Family]
+
+	if !ok {
+		return 0, fmt.Errorf("%w: rcc of %s devices", errClockNotDecoded, device.Family)
+	}
+
+	clock, err := decoder(h, device, hseHz)
+
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Debugf("%s core clock from rcc: %d Hz", device.Family, clock)
+
+	return clock, nil
+}
+
+// divisor of the HPRE ahb prescaler field
+func ahbPrescaler(hpre uint32) uint32 {
+	if (hpre & 0x8) == 0 {
+		return 1
+	}
+
+	return []uint32{2, 4, 8, 16, 64, 128, 256, 512}[hpre&0x7]
+}
+
+// checks that the frequency of the external oscillator is known
+func hseClock(hseHz uint32) (uint32, error) {
+	if hseHz == 0 {
+		return 0, fmt.Errorf("%w: clock is fed by the hse, hse frequency unknown", errClockNotDecoded)
+	}
+
+	return hseHz, nil
+}
+
+func rccClockF0F3(h *StLink, device Stm32Device, hseHz uint32) (uint32, error) {
+	const hsi = 8000000
+
+	cfgr, err := h.readUint32(rccBaseAhb + 0x04)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var sysclk uint32
+
+	switch (cfgr >> 2) & 0x3 {
+	case 0:
+		sysclk = hsi
+	case 1:
+		if sysclk, err = hseClock(hseHz); err != nil {
+			return 0, err
+		}
+	case 2:
+		cfgr2, err := h.readUint32(rccBaseAhb + 0x2C)
+
+		if err != nil {
+			return 0, err
+		}
+
+		prediv := (cfgr2 & 0xf) + 1
+		var input uint32
+
+		switch (cfgr >> 15) & 0x3 {
+		case 0:
+			input = hsi / 2
+		case 1:
+			input = hsi / prediv
+		case 2:
+			if input, err = hseClock(hseHz); err != nil {
+				return 0, err
+			}
+
+			input /= prediv
+		case 3:
+			input = 48000000 / prediv
+		}
+
+		sysclk = input * pllMulF0F1F3(cfgr)
+	case 3:
+		sysclk = 48000000
+	}
+
+	return sysclk / ahbPrescaler((cfgr>>4)&0xf), nil
+}
+
+func rccClockF1(h *StLink, device Stm32Device, hseHz uint32) (uint32, error) {
+	const hsi = 8000000
+
+	cfgr, err := h.readUint32(rccBaseAhb + 0x04)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var sysclk uint32
+
+	switch (cfgr >> 2) & 0x3 {
+	case 0:
+		sysclk = hsi
+	case 1:
+		if sysclk, err = hseClock(hseHz); err != nil {
+			return 0, err
+		}
+	case 2:
+		var input uint32
+
+		if (cfgr & (1 << 16)) == 0 {
+			input = hsi / 2
+		} else if device.DevId == 0x418 {
+			// the connectivity line feeds the pll through PLL2 and PREDIV1
+			return 0, fmt.Errorf("%w: pll of connectivity line devices", errClockNotDecoded)
+		} else {
+			if input, err = hseClock(hseHz); err != nil {
+				return 0, err
+			}
+
+			if device.DevId == 0x420 || device.DevId == 0x428 {
+				// value line devices divide by PREDIV1 of CFGR2
+				cfgr2, err := h.readUint32(rccBaseAhb + 0x2C)
+
+				if err != nil {
+					return 0, err
+				}
+
+				input /= (cfgr2 & 0xf) + 1
+			} else if (cfgr & (1 << 17)) > 0 {
+				input /= 2
+			}
+		}
+
+		sysclk = input * pllMulF0F1F3(cfgr)
+	default:
+		return 0, fmt.Errorf("invalid system clock switch in RCC_CFGR %08x", cfgr)
+	}
+
+	return sysclk / ahbPrescaler((cfgr>>4)&0xf), nil
+}
+
+// PLLMUL of RCC_CFGR, multiplies from 2 and saturates at 16
+func pllMulF0F1F3(cfgr uint32) uint32 {
+	mul := ((cfgr >> 18) & 0xf) + 2
+
+	if mul > 16 {
+		return 16
+	}
+
+	return mul
+}
+
+func rccClockF2F4F7(h *StLink, device Stm32Device, hseHz uint32) (uint32, error) {
+	const hsi = 16000000
+
+	cfgr, err := h.readUint32(rccBaseAhb1 + 0x08)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var sysclk uint32
+	sws := (cfgr >> 2) & 0x3
+
+	switch sws {
+	case 0:
+		sysclk = hsi
+	case 1:
+		if sysclk, err = hseClock(hseHz); err != nil {
+			return 0, err
+		}
+	case 2, 3:
+		pllcfgr, err := h.readUint32(rccBaseAhb1 + 0x04)
+
+		if err != nil {
+			return 0, err
+		}
+
+		input := uint32(hsi)
+
+		if (pllcfgr & (1 << 22)) > 0 {
+			if input, err = hseClock(hseHz); err != nil {
+				return 0, err
+			}
+		}
+
+		m := pllcfgr & 0x3f
+
+		if m == 0 {
+			return 0, fmt.Errorf("invalid pll divider in RCC_PLLCFGR %08x", pllcfgr)
+		}
+
+		vco := uint64(input) * uint64((pllcfgr>>6)&0x1ff) / uint64(m)
+
+		// PLLR is a system clock source of STM32F446 and later devices only
+		if sws == 3 {
+			r := uint64((pllcfgr >> 28) & 0x7)
+
+			if r < 2 {
+				return 0, fmt.Errorf("invalid pll divider in RCC_PLLCFGR %08x", pllcfgr)
+			}
+
+			sysclk = uint32(vco / r)
+		} else {
+			sysclk = uint32(vco / uint64((((pllcfgr>>16)&0x3)+1)*2))
+		}
+	}
+
+	return sysclk / ahbPrescaler((cfgr>>4)&0xf), nil
+}
+
+// STM32L0 and STM32L1 share the clock tree, the registers differ in base and
+// offset of RCC_CFGR
+func rccClockL0L1(h *StLink, device Stm32Device, hseHz uint32) (uint32, error) {
+	base, cfgrOffset := uint32(rccBaseAhb1), uint32(0x08)
+
+	if device.Family == Stm32FamilyL0 {
+		base, cfgrOffset = rccBaseAhb, 0x0C
+	}
+
+	cr, err := h.readUint32(base)
+
+	if err != nil {
+		return 0, err
+	}
+
+	cfgr, err := h.readUint32(base + cfgrOffset)
+
+	if err != nil {
+		return 0, err
+	}
+
+	hsi := uint32(16000000)
+
+	// HSI16DIVEN of STM32L0
+	if device.Family == Stm32FamilyL0 && (cr&(1<<3)) > 0 {
+		hsi /= 4
+	}
+
+	var sysclk uint32
+
+	switch (cfgr >> 2) & 0x3 {
+	case 0:
+		icscr, err := h.readUint32(base + 0x04)
+
+		if err != nil {
+			return 0, err
+		}
+
+		sysclk = 65536 << ((icscr >> 13) & 0x7)
+	case 1:
+		sysclk = hsi
+	case 2:
+		if sysclk, err = hseClock(hseHz); err != nil {
+			return 0, err
+		}
+	case 3:
+		input := hsi
+
+		if (cfgr & (1 << 16)) > 0 {
+			if input, err = hseClock(hseHz); err != nil {
+				return 0, err
+			}
+		}
+
+		mul := (cfgr >> 18) & 0xf
+		div := (cfgr >> 22) & 0x3
+
+		if int(mul) >= len(pllMulL0L1) || div == 0 {
+			return 0, fmt.Errorf("invalid pll configuration in RCC_CFGR %08x", cfgr)
+		}
+
+		sysclk = input * pllMulL0L1[mul] / (div + 1)
+	}
+
+	return sysclk / ahbPrescaler((cfgr>>4)&0xf), nil
+}
+
+// STM32L4, STM32G4 and STM32WB share the register layout, the G4 has no msi and
+// the WB can divide the hse by 2 (HSEPRE) and has a wider PLLR field
+func rccClockL4G4Wb(h *StLink, device Stm32Device, hseHz uint32) (uint32, error) {
+	const hsi = 16000000
+
+	base := uint32(rccBaseAhb)
+
+	if device.Family == Stm32FamilyWB {
+		base = rccBaseWb
+	}
+
+	cr, err := h.readUint32(base)
+
+	if err != nil {
+		return 0, err
+	}
+
+	cfgr, err := h.readUint32(base + 0x08)
+
+	if err != nil {
+		return 0, err
+	}
+
+	msi := func() (uint32, error) {
+		if device.Family == Stm32FamilyG4 {
+			return 0, fmt.Errorf("invalid clock source in RCC_CFGR %08x", cfgr)
+		}
+
+		msiRange := (cr >> 4) & 0xf
+
+		// STM32L4 takes the range from RCC_CSR until MSIRGSEL is set
+		if device.Family == Stm32FamilyL4 && (cr&(1<<3)) == 0 {
+			csr, err := h.readUint32(base + 0x94)
+
+			if err != nil {
+				return 0, err
+			}
+
+			msiRange = (csr >> 8) & 0xf
+		}
+
+		if int(msiRange) >= len(msiRangesL4) {
+			return 0, fmt.Errorf("invalid msi range %d", msiRange)
+		}
+
+		return msiRangesL4[msiRange], nil
+	}
+
+	var sysclk uint32
+
+	switch (cfgr >> 2) & 0x3 {
+	case 0:
+		if sysclk, err = msi(); err != nil {
+			return 0, err
+		}
+	case 1:
+		sysclk = hsi
+	case 2:
+		if sysclk, err = hseClock(hseHz); err != nil {
+			return 0, err
+		}
+
+		if device.Family == Stm32FamilyWB && (cr&(1<<20)) > 0 {
+			sysclk /= 2
+		}
+	case 3:
+		pllcfgr, err := h.readUint32(base + 0x0C)
+
+		if err != nil {
+			return 0, err
+		}
+
+		var input uint32
+
+		switch pllcfgr & 0x3 {
+		case 0:
+			return 0, fmt.Errorf("pll without clock source in RCC_PLLCFGR %08x", pllcfgr)
+		case 1:
+			if input, err = msi(); err != nil {
+				return 0, err
+			}
+		case 2:
+			input = hsi
+		case 3:
+			if input, err = hseClock(hseHz); err != nil {
+				return 0, err
+			}
+		}
+
+		var r uint64
+
+		if device.Family == Stm32FamilyWB {
+			r = uint64((pllcfgr>>29)&0x7) + 1
+		} else {
+			r = (uint64((pllcfgr>>25)&0x3) + 1) * 2
+		}
+
+		m := uint64((pllcfgr>>4)&0xf) + 1
+		sysclk = uint32(uint64(input) * uint64((pllcfgr>>8)&0x7f) / m / r)
+	}
+
+	return sysclk / ahbPrescaler((cfgr>>4)&0xf), nil
+}
+
+func rccClockG0(h *StLink, device Stm32Device, hseHz uint32) (uint32, error) {
+	const hsi = 16000000
+
+	cr, err := h.readUint32(rccBaseAhb)
+
+	if err != nil {
+		return 0, err
+	}
+
+	cfgr, err := h.readUint32(rccBaseAhb + 0x08)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var sysclk uint32
+
+	switch (cfgr >> 3) & 0x7 {
+	case 0:
+		sysclk = hsi >> ((cr >> 11) & 0x7)
+	case 1:
+		if sysclk, err = hseClock(hseHz); err != nil {
+			return 0, err
+		}
+	case 2:
+		pllcfgr, err := h.readUint32(rccBaseAhb + 0x0C)
+
+		if err != nil {
+			return 0, err
+		}
+
+		var input uint32
+
+		switch pllcfgr & 0x3 {
+		case 2:
+			input = hsi
+		case 3:
+			if input, err = hseClock(hseHz); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("pll without clock source in RCC_PLLCFGR %08x", pllcfgr)
+		}
+
+		m := uint64((pllcfgr>>4)&0x7) + 1
+		r := uint64((pllcfgr>>29)&0x7) + 1
+		sysclk = uint32(uint64(input) * uint64((pllcfgr>>8)&0x7f) / m / r)
+	case 3:
+		sysclk = 32000
+	case 4:
+		sysclk = 32768
+	default:
+		return 0, fmt.Errorf("invalid system clock switch in RCC_CFGR %08x", cfgr)
+	}
+
+	return sysclk / ahbPrescaler((cfgr>>8)&0xf), nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRccClock(t *testing.T) {
+	tests := []struct {
+		name      string
+		device    Stm32Device
+		base      uint32
+		registers map[uint32]uint32 // offset to value
+		hseHz     uint32
+		want      uint32
+	}{
+		{"F4 hsi ahb/4", Stm32Device{DevId: 0x413, Family: Stm32FamilyF4}, rccBaseAhb1,
+			map[uint32]uint32{0x08: 0x9 << 4}, 0, 4000000},
+		{"F4 pll hse", Stm32Device{DevId: 0x413, Family: Stm32FamilyF4}, rccBaseAhb1,
+			map[uint32]uint32{0x08: 2 << 2, 0x04: 8 | 336<<6 | 1<<22}, 8000000, 168000000},
+		{"F1 pll hse", Stm32Device{DevId: 0x410, Family: Stm32FamilyF1}, rccBaseAhb,
+			map[uint32]uint32{0x04: 2<<2 | 1<<16 | 7<<18}, 8000000, 72000000},
+		{"F0 pll hsi/2", Stm32Device{DevId: 0x440, Family: Stm32FamilyF0}, rccBaseAhb,
+			map[uint32]uint32{0x04: 2<<2 | 10<<18}, 0, 48000000},
+		{"L1 pll hsi", Stm32Device{DevId: 0x416, Family: Stm32FamilyL1}, rccBaseAhb1,
+			map[uint32]uint32{0x08: 3<<2 | 2<<18 | 2<<22}, 0, 32000000},
+		{"L0 msi", Stm32Device{DevId: 0x417, Family: Stm32FamilyL0}, rccBaseAhb,
+			map[uint32]uint32{0x04: 5 << 13}, 0, 2097152},
+		{"L4 pll msi from csr", Stm32Device{DevId: 0x415, Family: Stm32FamilyL4}, rccBaseAhb,
+			map[uint32]uint32{0x08: 3 << 2, 0x0C: 1 | 40<<8, 0x94: 6 << 8}, 0, 80000000},
+		{"G4 pll hsi", Stm32Device{DevId: 0x468, Family: Stm32FamilyG4}, rccBaseAhb,
+			map[uint32]uint32{0x08: 3 << 2, 0x0C: 2 | 3<<4 | 85<<8}, 0, 170000000},
+		{"WB hse/2", Stm32Device{DevId: 0x495, Family: Stm32FamilyWB}, rccBaseWb,
+			map[uint32]uint32{0x00: 1 << 20, 0x08: 2 << 2}, 32000000, 16000000},
+		{"G0 hsisys ahb/2", Stm32Device{DevId: 0x460, Family: Stm32FamilyG0}, rccBaseAhb,
+			map[uint32]uint32{0x00: 1 << 11, 0x08: 0x8 << 8}, 0, 4000000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeStLink(test.base, 0x100)
+			h := newFakeHandle(f)
+			h.device = &test.device
+
+			for offset, value := range test.registers {
+				f.pokeUint32(test.base+offset, value)
+			}
+
+			clock, err := h.RccClock(test.hseHz)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if clock != test.want {
+				t.Errorf("clock = %d Hz, want %d Hz", clock, test.want)
+			}
+		})
+	}
+}
+
+func TestRccClockNotDecoded(t *testing.T) {
+	f := newFakeStLink(rccBaseAhb1, 0x100)
+	h := newFakeHandle(f)
+
+	// hse selected without a known hse frequency
+	h.device = &Stm32Device{DevId: 0x413, Family: Stm32FamilyF4}
+	f.pokeUint32(rccBaseAhb1+0x08, 1<<2)
+
+	if _, err := h.RccClock(0); !errors.Is(err, errClockNotDecoded) {
+		t.Errorf("err = %v, want errClockNotDecoded", err)
+	}
+
+	h.device = &Stm32Device{DevId: 0x450, Family: Stm32FamilyH7}
+
+	if _, err := h.RccClock(8000000); !errors.Is(err, errClockNotDecoded) {
+		t.Errorf("err = %v, want errClockNotDecoded", err)
+	}
+}
+
+func TestTraceClockFromRcc(t *testing.T) {
+	f := newFakeStLink(rccBaseAhb1, 0x100)
+	h := newFakeHandle(f)
+	h.device = &Stm32Device{DevId: 0x413, Family: Stm32FamilyF4}
+
+	f.pokeUint32(rccBaseAhb1+0x08, 2<<2)
+	f.pokeUint32(rccBaseAhb1+0x04, 25|336<<6|1<<16|1<<22)
+
+	clock, err := h.TraceClock(25000000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clock != 84000000 {
+		t.Errorf("trace clock = %d Hz, want 84000000 Hz", clock)
+	}
+
+	if n := f.commandCount(debugReadMem32Bit); n != 2 {
+		t.Errorf("%d memory reads, want the 2 rcc registers only", n)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// drives two handles with keep alive from two goroutines, run with -race to
+// check the handles share no unsynchronized state
+func TestConcurrentHandles(t *testing.T) {
+	quietLogger := logrus.New()
+	quietLogger.SetOutput(ioutil.Discard)
+	quietLogger.SetLevel(logrus.TraceLevel)
+
+	defer SetLogger(logger)
+	SetLogger(quietLogger)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+
+	for probe := 0; probe < 2; probe++ {
+		h := newFakeHandle(newFakeStLink(fakeRamStart, 0x400))
+		h.EnableKeepAlive(time.Millisecond)
+
+		wg.Add(1)
+
+		go func(probe int, h *StLink) {
+			defer wg.Done()
+			defer h.DisableKeepAlive()
+
+			for i := 0; i < 50; i++ {
+				data := bytes.Repeat([]byte{byte(probe), byte(i)}, 64)
+				addr := uint32(fakeRamStart + (i%4)*0x80)
+
+				if err := h.WriteMem(addr, Memory32BitBlock, uint32(len(data)/4), data); err != nil {
+					errs <- fmt.Errorf("probe %d: write: %v", probe, err)
+					return
+				}
+
+				read, err := h.ReadBytes(addr, uint32(len(data)))
+
+				if err != nil {
+					errs <- fmt.Errorf("probe %d: read: %v", probe, err)
+					return
+				}
+
+				if !bytes.Equal(read, data) {
+					errs <- fmt.Errorf("probe %d: read back % x", probe, read[:4])
+					return
+				}
+
+				// a failing access logs through the shared logger
+				if _, err = h.ReadBytes(0x08000000, 4); err == nil {
+					errs <- fmt.Errorf("probe %d: read outside of ram succeeded", probe)
+					return
+				}
+
+				h.Stats()
+
+				// idle long enough for keep alive requests in between
+				if i%10 == 0 {
+					time.Sleep(3 * time.Millisecond)
+				}
+			}
+		}(probe, h)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
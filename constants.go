@@ -20,6 +20,23 @@ const (
 	StLinkModeDebugSwim            = 5
 )
 
+func (m StLinkMode) String() string {
+	switch m {
+	case StLinkModeDfu:
+		return "dfu"
+	case StLinkModeMass:
+		return "mass"
+	case StLinkModeDebugJtag:
+		return "jtag"
+	case StLinkModeDebugSwd:
+		return "swd"
+	case StLinkModeDebugSwim:
+		return "swim"
+	default:
+		return "unknown"
+	}
+}
+
 type MemoryBlockSize int // block size for read and write operations
 
 const (
@@ -42,6 +59,7 @@ const (
 	flagHasDpBankSel        = 0x09
 	flagHasRw8Bytes512      = 0x0a
 	flagFixCloseAp          = 0x0b
+	flagHasCsw              = 0x0c
 )
 
 type stLinkApiVersion uint8 // api versions of stlinks
@@ -246,12 +264,58 @@ const (
 
 	cmdBufferSize  = 31
 	dataBufferSize = 4096
-	//cmdSizeV1        = 10
-	cmdSizeV2 = 16
+	cmdSizeV1      = 10
+	cmdSizeV2      = 16
 
 	traceSize  = 4096
 	traceMaxHz = 2000000
 
+	minimumTargetVoltage = 1.5
+
 	//STLINK_DEBUG_PORT_ACCESS = 0xffff
 	//STLINK_SERIAL_LEN  = 24
 )
+
+// cortex-m debug registers
+const (
+	dhcsrRegister = 0xE000EDF0
+	demcrRegister = 0xE000EDFC
+	aircrRegister = 0xE000ED0C
+
+	dhcsrDbgKey   = 0xA05F << 16
+	dhcsrCDebugEn = 1 << 0
+	dhcsrCHalt    = 1 << 1
+	dhcsrSHalt    = 1 << 17
+	dhcsrSSleep   = 1 << 18
+	dhcsrSLockup  = 1 << 19
+
+	aircrVectKey     = 0x05FA << 16
+	aircrSysResetReq = 1 << 2
+
+	demcrVcCoreReset = 1 << 0
+	demcrTrcEna      = 1 << 24
+
+	dwtCtrlRegister   = 0xE0001000
+	dwtCycCntRegister = 0xE0001004
+
+	dwtCtrlCycCntEna = 1 << 0
+	dwtCtrlNoCycCnt  = 1 << 25
+
+	tpiuAcprRegister = 0xE0040010
+	tpiuSpprRegister = 0xE00400F0
+	tpiuFfcrRegister = 0xE0040304
+
+	tpiuSpprNrz    = 2
+	tpiuFfcrTrigIn = 1 << 8
+
+	itmTerRegister = 0xE0000E00
+	itmTprRegister = 0xE0000E40
+	itmTcrRegister = 0xE0000E80
+	itmLarRegister = 0xE0000FB0
+
+	itmLarUnlockKey = 0xC5ACCE55
+	itmTcrItmEna    = 1 << 0
+	itmTcrSyncEna   = 1 << 2
+	itmTcrTxEna     = 1 << 3
+	itmTcrBusId     = 1 << 16 // trace bus id 1
+)
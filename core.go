@@ -0,0 +1,269 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const resetHaltTimeout = 500 * time.Millisecond
+
+const haltPollInterval = 10 * time.Millisecond
+
+type CoreState int // execution state of the connected cortex-m core
+
+const (
+	CoreStateUnknown  CoreState = 0
+	CoreStateRunning            = 1
+	CoreStateHalted             = 2
+	CoreStateLockedUp           = 3
+)
+
+func (s CoreState) String() string {
+	switch s {
+	case CoreStateRunning:
+		return "running"
+	case CoreStateHalted:
+		return "halted"
+	case CoreStateLockedUp:
+		return "locked up"
+	default:
+		return "unknown"
+	}
+}
+
+// decoded content of the cpuid base register (SCB CPUID)
+type CpuIdInfo struct {
+	Raw         uint32
+	Implementer uint8  // 0x41 for arm
+	Variant     uint8  // major revision, the r in rNpM
+	PartNo      uint16 // 0xc24 for cortex-m4, 0xc27 for cortex-m7, ...
+	Revision    uint8  // minor revision, the p in rNpM
+}
+
+func decodeCpuId(raw uint32) CpuIdInfo {
+	return CpuIdInfo{
+		Raw:         raw,
+		Implementer: uint8(raw >> 24),
+		Variant:     uint8((raw >> 20) & 0xf),
+		PartNo:      uint16((raw >> 4) & 0xfff),
+		Revision:    uint8(raw & 0xf),
+	}
+}
+
+// CpuId returns the decoded CPUID register, the value read on connect is reused
+func (h *StLink) CpuId() (CpuIdInfo, error) {
+	if h.cpuId == 0 {
+		raw, err := h.readUint32(cpuIdBaseRegister)
+
+		if err != nil {
+			return CpuIdInfo{}, err
+		}
+
+		h.cpuId = raw
+	}
+
+	return decodeCpuId(h.cpuId), nil
+}
+
+// CoreStatus reads DHCSR without halting the core
+func (h *StLink) CoreStatus() (CoreState, error) {
+	dhcsr, err := h.readUint32(dhcsrRegister)
+
+	if err != nil {
+		return CoreStateUnknown, err
+	}
+
+	logger.Tracef("read DHCSR: %08x", dhcsr)
+
+	if (dhcsr & dhcsrSLockup) > 0 {
+		return CoreStateLockedUp, nil
+	} else if (dhcsr & dhcsrSHalt) > 0 {
+		return CoreStateHalted, nil
+	} else {
+		return CoreStateRunning, nil
+	}
+}
+
+// halts the core if requested and releases a reset asserted on connect
+func (h *StLink) connectCore(config *StLinkInterfaceConfig) error {
+	if h.stMode == StLinkModeDebugSwim {
+		return nil
+	}
+
+	if config.haltOnConnect {
+		logger.Debug("halt core on connect")
+
+		if err := h.writeUint32(dhcsrRegister, dhcsrDbgKey|dhcsrCDebugEn|dhcsrCHalt); err != nil {
+			return err
+		}
+
+		if config.connectUnderReset {
+			// catch the core at the reset vector
+			if err := h.modifyDemcr(demcrVcCoreReset, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if config.connectUnderReset {
+		logger.Trace("Deassert RST line")
+
+		if err := h.usbAssertSrst(1); err != nil {
+			return err
+		}
+
+		if config.haltOnConnect {
+			if err := h.modifyDemcr(demcrVcCoreReset, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	state, err := h.CoreStatus()
+
+	if err != nil {
+		logger.Warn("could not read core state after connect: ", err)
+	} else {
+		logger.Debugf("core is %s after connect", state)
+	}
+
+	h.initialCoreState = state
+
+	return nil
+}
+
+// InitialCoreState returns the state of the core right after connecting (and
+// releasing a reset asserted on connect), unknown if it could not be read
+func (h *StLink) InitialCoreState() CoreState {
+	return h.initialCoreState
+}
+
+func (h *StLink) modifyDemcr(bits uint32, set bool) error {
+	return h.modifyRegister(demcrRegister, bits, set)
+}
+
+func (h *StLink) modifyRegister(address uint32, bits uint32, set bool) error {
+	value, err := h.readUint32(address)
+
+	if err != nil {
+		return err
+	}
+
+	if set {
+		value |= bits
+	} else {
+		value &^= bits
+	}
+
+	return h.writeUint32(address, value)
+}
+
+// ResetRun resets the system with SYSRESETREQ and lets the core run from the
+// reset vector, a halted core is released
+func (h *StLink) ResetRun() error {
+	if err := h.modifyDemcr(demcrVcCoreReset, false); err != nil {
+		return err
+	}
+
+	if err := h.writeUint32(dhcsrRegister, dhcsrDbgKey|dhcsrCDebugEn); err != nil {
+		return err
+	}
+
+	return h.writeUint32(aircrRegister, aircrVectKey|aircrSysResetReq)
+}
+
+// ResetHalt resets the system with SYSRESETREQ and halts the core at the reset
+// vector before the first instruction is executed
+func (h *StLink) ResetHalt() error {
+	if err := h.writeUint32(dhcsrRegister, dhcsrDbgKey|dhcsrCDebugEn); err != nil {
+		return err
+	}
+
+	if err := h.modifyDemcr(demcrVcCoreReset, true); err != nil {
+		return err
+	}
+
+	if err := h.writeUint32(aircrRegister, aircrVectKey|aircrSysResetReq); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(resetHaltTimeout)
+
+	for {
+		state, err := h.CoreStatus()
+
+		if err == nil && state == CoreStateHalted {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = errors.New("core did not halt at reset vector")
+			}
+
+			return err
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return h.modifyDemcr(demcrVcCoreReset, false)
+}
+
+// WaitHalted polls DHCSR until the core is halted (e.g. at a breakpoint) and
+// returns ErrHaltTimeout if it is still running after timeout
+func (h *StLink) WaitHalted(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		dhcsr, err := h.readUint32(dhcsrRegister)
+
+		if err != nil {
+			return err
+		}
+
+		if (dhcsr & dhcsrSHalt) > 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return ErrHaltTimeout
+		}
+
+		time.Sleep(haltPollInterval)
+	}
+}
+
+// single register write of WriteRegisters
+type RegWrite struct {
+	Address uint32
+	Value   uint32
+}
+
+// WriteRegisters performs the writes in order and stops at the first failing
+// one. Writes to consecutive addresses are sent as a single transfer.
+func (h *StLink) WriteRegisters(writes []RegWrite) error {
+	for start := 0; start < len(writes); {
+		values := []uint32{writes[start].Value}
+
+		for start+len(values) < len(writes) &&
+			writes[start+len(values)].Address == writes[start].Address+uint32(len(values))*4 {
+
+			values = append(values, writes[start+len(values)].Value)
+		}
+
+		if err := h.writeMemU32Slice(writes[start].Address, values, littleEndian); err != nil {
+			return fmt.Errorf("register write %d (0x%08x = 0x%08x) failed: %w",
+				start, writes[start].Address, writes[start].Value, err)
+		}
+
+		start += len(values)
+	}
+
+	return nil
+}
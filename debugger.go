@@ -6,7 +6,6 @@ package gostlink
 
 import (
 	"errors"
-	"time"
 )
 
 /** Issue an STLINK command via USB transfer, with retries on any wait status responses.
@@ -21,6 +20,9 @@ func (h *StLink) usbCmdAllowRetry(ctx *transferCtx, size uint32) error {
 
 	for true {
 		if (h.stMode != StLinkModeDebugSwim) || retries > 0 {
+			// drop the response of the previous try
+			ctx.dataBuf.Zero()
+
 			err := h.usbTransferNoErrCheck(ctx, size)
 			if err != nil {
 				return err
@@ -39,14 +41,9 @@ func (h *StLink) usbCmdAllowRetry(ctx *transferCtx, size uint32) error {
 		err := h.usbErrorCheck(ctx)
 
 		if err != nil {
-			usbError := err.(*usbError)
-
-			if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-				var delayUs time.Duration = (1 << retries) * 1000
-
-				retries++
-				logger.Debugf("cmdAllowRetry ERROR_WAIT, retry %d, delaying %d microseconds", retries, delayUs)
-				time.Sleep(delayUs * 1000)
+			if isWaitError(err) && retries < h.retryPolicy.MaxRetries {
+				logger.Debug("cmdAllowRetry ERROR_WAIT")
+				retries = h.backoffSleep(retries)
 
 				continue
 			}
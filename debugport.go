@@ -0,0 +1,36 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// decoded content of the debug port identification register (DPIDR)
+type DpIdCode struct {
+	Raw      uint32 // raw register value as returned by GetIdCode
+	Designer uint16 // JEP106 designer code (continuation code in bits [10:7])
+	Version  uint8  // debug port architecture version (DPv0, DPv1, DPv2)
+	Min      bool   // minimal debug port (no pushed operations)
+	PartNo   uint8  // part number of the debug port
+	Revision uint8  // revision of the debug port implementation
+}
+
+func decodeDpIdCode(raw uint32) DpIdCode {
+	return DpIdCode{
+		Raw:      raw,
+		Designer: uint16((raw >> 1) & 0x7ff),
+		Version:  uint8((raw >> 12) & 0xf),
+		Min:      ((raw >> 16) & 0x1) == 1,
+		PartNo:   uint8((raw >> 20) & 0xff),
+		Revision: uint8((raw >> 28) & 0xf),
+	}
+}
+
+func (h *StLink) DebugPortIdCode() (DpIdCode, error) {
+	raw, err := h.GetIdCode()
+
+	if err != nil {
+		return DpIdCode{}, err
+	}
+
+	return decodeDpIdCode(raw), nil
+}
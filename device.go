@@ -0,0 +1,294 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Stm32Family int // stm32 product line detected by DBGMCU_IDCODE
+
+const (
+	Stm32FamilyUnknown Stm32Family = 0
+	Stm32FamilyF0                  = 1
+	Stm32FamilyF1                  = 2
+	Stm32FamilyF2                  = 3
+	Stm32FamilyF3                  = 4
+	Stm32FamilyF4                  = 5
+	Stm32FamilyF7                  = 6
+	Stm32FamilyG0                  = 7
+	Stm32FamilyG4                  = 8
+	Stm32FamilyH7                  = 9
+	Stm32FamilyL0                  = 10
+	Stm32FamilyL1                  = 11
+	Stm32FamilyL4                  = 12
+	Stm32FamilyWB                  = 13
+)
+
+var stm32FamilyNames = map[Stm32Family]string{
+	Stm32FamilyF0: "STM32F0",
+	Stm32FamilyF1: "STM32F1",
+	Stm32FamilyF2: "STM32F2",
+	Stm32FamilyF3: "STM32F3",
+	Stm32FamilyF4: "STM32F4",
+	Stm32FamilyF7: "STM32F7",
+	Stm32FamilyG0: "STM32G0",
+	Stm32FamilyG4: "STM32G4",
+	Stm32FamilyH7: "STM32H7",
+	Stm32FamilyL0: "STM32L0",
+	Stm32FamilyL1: "STM32L1",
+	Stm32FamilyL4: "STM32L4",
+	Stm32FamilyWB: "STM32WB",
+}
+
+func (f Stm32Family) String() string {
+	if name, ok := stm32FamilyNames[f]; ok {
+		return name
+	} else {
+		return "unknown"
+	}
+}
+
+// DEV_ID field of DBGMCU_IDCODE to product line
+var stm32DeviceIds = map[uint16]Stm32Family{
+	0x440: Stm32FamilyF0, 0x442: Stm32FamilyF0, 0x444: Stm32FamilyF0, 0x445: Stm32FamilyF0, 0x448: Stm32FamilyF0,
+	0x410: Stm32FamilyF1, 0x412: Stm32FamilyF1, 0x414: Stm32FamilyF1, 0x418: Stm32FamilyF1, 0x420: Stm32FamilyF1,
+	0x428: Stm32FamilyF1, 0x430: Stm32FamilyF1,
+	0x411: Stm32FamilyF2,
+	0x422: Stm32FamilyF3, 0x432: Stm32FamilyF3, 0x438: Stm32FamilyF3, 0x439: Stm32FamilyF3, 0x446: Stm32FamilyF3,
+	0x413: Stm32FamilyF4, 0x419: Stm32FamilyF4, 0x421: Stm32FamilyF4, 0x423: Stm32FamilyF4, 0x431: Stm32FamilyF4,
+	0x433: Stm32FamilyF4, 0x434: Stm32FamilyF4, 0x441: Stm32FamilyF4, 0x458: Stm32FamilyF4, 0x463: Stm32FamilyF4,
+	0x449: Stm32FamilyF7, 0x451: Stm32FamilyF7, 0x452: Stm32FamilyF7,
+	0x460: Stm32FamilyG0, 0x466: Stm32FamilyG0, 0x467: Stm32FamilyG0,
+	0x468: Stm32FamilyG4, 0x469: Stm32FamilyG4, 0x479: Stm32FamilyG4,
+	0x450: Stm32FamilyH7, 0x480: Stm32FamilyH7, 0x483: Stm32FamilyH7,
+	0x417: Stm32FamilyL0, 0x425: Stm32FamilyL0, 0x447: Stm32FamilyL0, 0x457: Stm32FamilyL0,
+	0x416: Stm32FamilyL1, 0x427: Stm32FamilyL1, 0x429: Stm32FamilyL1, 0x436: Stm32FamilyL1, 0x437: Stm32FamilyL1,
+	0x415: Stm32FamilyL4, 0x435: Stm32FamilyL4, 0x461: Stm32FamilyL4, 0x462: Stm32FamilyL4, 0x464: Stm32FamilyL4,
+	0x470: Stm32FamilyL4, 0x471: Stm32FamilyL4,
+	0x495: Stm32FamilyWB,
+}
+
+type Stm32Device struct {
+	DevId  uint16 // device id (DEV_ID of DBGMCU_IDCODE)
+	RevId  uint16 // silicon revision (REV_ID of DBGMCU_IDCODE)
+	Family Stm32Family
+}
+
+// DBGMCU_IDCODE locations, cortex-m0(+) parts map the DBGMCU on the APB bus
+const (
+	dbgMcuIdCodeCortexM0 = 0x40015800
+	dbgMcuIdCodeCortexM  = 0xE0042000
+	dbgMcuIdCodeH7       = 0x5C001000
+
+	cpuIdPartNoCortexM0     = 0xc20
+	cpuIdPartNoCortexM0Plus = 0xc60
+)
+
+// DetectDevice reads the stm32 device id, the result is cached on the handle
+func (h *StLink) DetectDevice() (Stm32Device, error) {
+	if h.device != nil {
+		return *h.device, nil
+	}
+
+	cpuId, err := h.readUint32(cpuIdBaseRegister)
+
+	if err != nil {
+		return Stm32Device{}, err
+	}
+
+	var idCodeAddresses []uint32
+
+	switch (cpuId >> 4) & 0xfff {
+	case cpuIdPartNoCortexM0, cpuIdPartNoCortexM0Plus:
+		idCodeAddresses = []uint32{dbgMcuIdCodeCortexM0}
+	default:
+		idCodeAddresses = []uint32{dbgMcuIdCodeCortexM, dbgMcuIdCodeH7}
+	}
+
+	for _, address := range idCodeAddresses {
+		idCode, err := h.readUint32(address)
+
+		if err != nil || (idCode&0xfff) == 0 {
+			logger.Tracef("no DBGMCU_IDCODE at %08x", address)
+			continue
+		}
+
+		device := Stm32Device{
+			DevId:  uint16(idCode & 0xfff),
+			RevId:  uint16(idCode >> 16),
+			Family: stm32DeviceIds[uint16(idCode&0xfff)],
+		}
+
+		logger.Debugf("detected %s device (dev id %03x, rev id %04x)", device.Family, device.DevId, device.RevId)
+
+		h.device = &device
+		return device, nil
+	}
+
+	return Stm32Device{}, errors.New("could not read stm32 device id from DBGMCU")
+}
+
+// peripherals which keep running or are stopped while the core is halted.
+// Apb1 and Apb2 hold the family specific DBGMCU_APBx_FZ bits (on STM32F1 the
+// Apb1 bits are placed in DBGMCU_CR), the watchdog flags are mapped to the
+// correct bits of the detected family.
+type DebugFreezeConfig struct {
+	Apb1 uint32
+	Apb2 uint32
+
+	IndependentWatchdog bool
+	WindowWatchdog      bool
+}
+
+type dbgMcuFreezeLayout struct {
+	apb1       uint32
+	apb2       uint32
+	iwdgBit    uint32
+	wwdgBit    uint32
+	clockReg   uint32 // rcc register enabling the DBGMCU clock, 0 if always clocked
+	clockBit   uint32
+	crFreezing bool // freeze bits are part of DBGMCU_CR (STM32F1)
+}
+
+var dbgMcuFreezeLayouts = map[Stm32Family]dbgMcuFreezeLayout{
+	Stm32FamilyF0: {0x40015808, 0x4001580C, 1 << 12, 1 << 11, 0x40021018, 1 << 22, false},
+	Stm32FamilyF1: {0xE0042004, 0, 1 << 8, 1 << 9, 0, 0, true},
+	Stm32FamilyF2: {0xE0042008, 0xE004200C, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyF3: {0xE0042008, 0xE004200C, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyF4: {0xE0042008, 0xE004200C, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyF7: {0xE0042008, 0xE004200C, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyG0: {0x40015808, 0x4001580C, 1 << 12, 1 << 11, 0x4002103C, 1 << 27, false},
+	Stm32FamilyG4: {0xE0042008, 0xE0042010, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyL0: {0x40015808, 0x4001580C, 1 << 12, 1 << 11, 0x40021034, 1 << 22, false},
+	Stm32FamilyL1: {0xE0042008, 0xE004200C, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyL4: {0xE0042008, 0xE0042010, 1 << 12, 1 << 11, 0, 0, false},
+	Stm32FamilyWB: {0xE004203C, 0xE004204C, 1 << 12, 1 << 11, 0, 0, false}, // C1 APB1FZR1 and APB2FZR
+}
+
+func (h *StLink) ConfigureDebugFreeze(cfg DebugFreezeConfig) error {
+	device, err := h.DetectDevice()
+
+	if err != nil {
+		return err
+	}
+
+	layout, ok := dbgMcuFreezeLayouts[device.Family]
+
+	if !ok {
+		return fmt.Errorf("debug freeze configuration not supported for %s devices", device.Family)
+	}
+
+	apb1 := cfg.Apb1
+
+	if cfg.IndependentWatchdog {
+		apb1 |= layout.iwdgBit
+	}
+
+	if cfg.WindowWatchdog {
+		apb1 |= layout.wwdgBit
+	}
+
+	if layout.clockReg != 0 {
+		clock, err := h.readUint32(layout.clockReg)
+
+		if err != nil {
+			return err
+		}
+
+		if err = h.writeUint32(layout.clockReg, clock|layout.clockBit); err != nil {
+			return err
+		}
+	}
+
+	if layout.crFreezing {
+		if cfg.Apb2 != 0 {
+			return fmt.Errorf("%s has no separate APB2 freeze register", device.Family)
+		}
+
+		// keep low power and trace configuration bits of DBGMCU_CR
+		cr, err := h.readUint32(layout.apb1)
+
+		if err != nil {
+			return err
+		}
+
+		apb1 |= cr & 0xff
+	}
+
+	logger.Debugf("set debug freeze APB1: %08x, APB2: %08x", apb1, cfg.Apb2)
+
+	if err = h.writeUint32(layout.apb1, apb1); err != nil {
+		return err
+	}
+
+	if layout.apb2 != 0 {
+		return h.writeUint32(layout.apb2, cfg.Apb2)
+	}
+
+	return nil
+}
+
+// address of the flash size register (size in KB) per family
+var stm32FlashSizeRegisters = map[Stm32Family]uint32{
+	Stm32FamilyF0: 0x1FFFF7CC,
+	Stm32FamilyF1: 0x1FFFF7E0,
+	Stm32FamilyF2: 0x1FFF7A22,
+	Stm32FamilyF3: 0x1FFFF7CC,
+	Stm32FamilyF4: 0x1FFF7A22,
+	Stm32FamilyF7: 0x1FF0F442,
+	Stm32FamilyG0: 0x1FFF75E0,
+	Stm32FamilyG4: 0x1FFF75E0,
+	Stm32FamilyH7: 0x1FF1E880,
+	Stm32FamilyL0: 0x1FF8007C,
+	Stm32FamilyL1: 0x1FF800CC,
+	Stm32FamilyL4: 0x1FFF75E0,
+	Stm32FamilyWB: 0x1FFF75E0,
+}
+
+// devices placing the flash size register apart from their family
+var stm32FlashSizeRegistersByDevId = map[uint16]uint32{
+	0x452: 0x1FF07A22, // STM32F72x/F73x
+	0x480: 0x08FFF80C, // STM32H7A3/B3
+	0x416: 0x1FF8004C, // STM32L1 cat.1
+	0x429: 0x1FF8004C, // STM32L1 cat.2
+}
+
+// FlashSizeKB reads the flash size of the detected stm32 device from its flash
+// size register
+func (h *StLink) FlashSizeKB() (uint16, error) {
+	device, err := h.DetectDevice()
+
+	if err != nil {
+		return 0, err
+	}
+
+	address, ok := stm32FlashSizeRegistersByDevId[device.DevId]
+
+	if !ok {
+		address, ok = stm32FlashSizeRegisters[device.Family]
+	}
+
+	if !ok {
+		return 0, fmt.Errorf("flash size register of %s device (dev id %03x) unknown", device.Family, device.DevId)
+	}
+
+	data, err := h.ReadBytes(address, 2)
+
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := tryConvertToUint16(data, littleEndian)
+
+	if err != nil {
+		return 0, err
+	}
+
+	logger.Debugf("flash size of %s device: %d KB", device.Family, size)
+
+	return size, nil
+}
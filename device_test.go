@@ -0,0 +1,58 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigureDebugFreezeAddresses(t *testing.T) {
+	// written registers: dbgmcu clock enable (if needed), APB1 and APB2 freeze
+	tests := map[Stm32Family][]uint32{
+		Stm32FamilyF0: {0x40021018, 0x40015808, 0x4001580C},
+		Stm32FamilyF1: {0xE0042004},
+		Stm32FamilyF2: {0xE0042008, 0xE004200C},
+		Stm32FamilyF3: {0xE0042008, 0xE004200C},
+		Stm32FamilyF4: {0xE0042008, 0xE004200C},
+		Stm32FamilyF7: {0xE0042008, 0xE004200C},
+		Stm32FamilyG0: {0x4002103C, 0x40015808, 0x4001580C},
+		Stm32FamilyG4: {0xE0042008, 0xE0042010},
+		Stm32FamilyL0: {0x40021034, 0x40015808, 0x4001580C},
+		Stm32FamilyL1: {0xE0042008, 0xE004200C},
+		Stm32FamilyL4: {0xE0042008, 0xE0042010},
+		Stm32FamilyWB: {0xE004203C, 0xE004204C},
+	}
+
+	for family, want := range tests {
+		t.Run(family.String(), func(t *testing.T) {
+			// the apb mapped DBGMCU and the rcc share the first ram region
+			f := newFakeStLink(0x40000000, 0x22000)
+
+			if want[0] >= 0xE0000000 {
+				f = newFakeStLink(0xE0042000, 0x100)
+			}
+
+			h := newFakeHandle(f)
+			h.device = &Stm32Device{Family: family}
+
+			if err := h.ConfigureDebugFreeze(DebugFreezeConfig{IndependentWatchdog: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			var written []uint32
+
+			for _, cmd := range f.commands {
+				if cmd[0] == cmdDebug && cmd[1] == debugWriteMem32Bit {
+					written = append(written, convertToUint32(cmd[2:], littleEndian))
+				}
+			}
+
+			if !reflect.DeepEqual(written, want) {
+				t.Errorf("written registers %08x, want %08x", written, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/gousb"
+)
+
+// StLinkDeviceInfo describes a connected st-link found by ListStLinks
+type StLinkDeviceInfo struct {
+	Vid    gousb.ID
+	Pid    gousb.ID
+	Serial string
+
+	Bus     int // usb bus number
+	Address int // usb device address on the bus
+}
+
+// vendor and product ids to search for with the given config
+func stLinkIds(config *StLinkInterfaceConfig) ([]gousb.ID, []gousb.ID) {
+	vids := []gousb.ID{config.vid}
+	pids := []gousb.ID{config.pid}
+
+	if config.vid == AllSupportedVIds {
+		vids = goStLinkSupportedVIds
+	}
+
+	if config.pid == AllSupportedPIds {
+		pids = goStLinkSupportedPIds
+	}
+
+	return vids, pids
+}
+
+// opens all connected st-links matching vid and pid of the config
+func usbFindStLinks(config *StLinkInterfaceConfig) ([]*gousb.Device, error) {
+	return usbFindDevices(stLinkIds(config))
+}
+
+// opens the matching st-links one after another until the one with the serial
+// of the config is found, the others are not kept open
+func usbOpenStLinkBySerial(config *StLinkInterfaceConfig) (*gousb.Device, error) {
+	descs, err := usbListDevices(stLinkIds(config))
+
+	if len(descs) == 0 {
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New("could not find any ST-Link connected to computer")
+	}
+
+	for _, desc := range descs {
+		dev, err := usbOpenDevice(desc)
+
+		if err != nil {
+			logger.Debugf("could not open usb device %03d:%03d: %s", desc.Bus, desc.Address, err)
+			continue
+		}
+
+		serial, _ := dev.SerialNumber()
+
+		logger.Tracef("compare serial no %s with number %s", serial, config.serial)
+
+		if serial == config.serial {
+			return dev, nil
+		}
+
+		dev.Close()
+	}
+
+	return nil, fmt.Errorf("could not find st-link with serial number %s", config.serial)
+}
+
+// ListStLinks returns all connected st-links matching vid, pid and (if set) the
+// serial of the config. The devices are closed again before returning.
+func ListStLinks(config *StLinkInterfaceConfig) ([]StLinkDeviceInfo, error) {
+	devices, err := usbFindStLinks(config)
+
+	if len(devices) == 0 {
+		if err != nil {
+			return nil, err
+		}
+
+		return []StLinkDeviceInfo{}, nil
+	}
+
+	infos := make([]StLinkDeviceInfo, 0, len(devices))
+
+	for _, dev := range devices {
+		serial, err := dev.SerialNumber()
+
+		if err != nil {
+			logger.Debugf("could not read serial number of usb device %s: %s", dev, err)
+		}
+
+		if config.serial == "" || serial == config.serial {
+			infos = append(infos, StLinkDeviceInfo{
+				Vid:     dev.Desc.Vendor,
+				Pid:     dev.Desc.Product,
+				Serial:  serial,
+				Bus:     dev.Desc.Bus,
+				Address: dev.Desc.Address,
+			})
+		}
+
+		dev.Close()
+	}
+
+	return infos, nil
+}
+
+// NewStLinkSelect opens a st-link like NewStLink. If no serial is configured
+// and more than one st-link matches, chooser is called with the matching
+// st-links and returns the index of the one to open.
+func NewStLinkSelect(config *StLinkInterfaceConfig, chooser func([]StLinkDeviceInfo) int) (*StLink, error) {
+	if config.serial != "" {
+		return NewStLink(config)
+	}
+
+	infos, err := ListStLinks(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(infos) <= 1 {
+		return NewStLink(config)
+	}
+
+	index := chooser(infos)
+
+	if index < 0 || index >= len(infos) {
+		return nil, fmt.Errorf("no st-link selected (index %d of %d devices)", index, len(infos))
+	}
+
+	if infos[index].Serial == "" {
+		return nil, errors.New("selected st-link has no serial number to open it by")
+	}
+
+	selected := *config
+	selected.vid = infos[index].Vid
+	selected.pid = infos[index].Pid
+	selected.serial = infos[index].Serial
+
+	return NewStLink(&selected)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+)
+
+var commandNames = map[byte]string{
+	cmdRequestSense:        "REQUEST_SENSE",
+	cmdGetVersion:          "GET_VERSION",
+	cmdDebug:               "DEBUG",
+	cmdDfu:                 "DFU",
+	cmdSwim:                "SWIM",
+	cmdGetCurrentMode:      "GET_CURRENT_MODE",
+	cmdGetTargetVoltage:    "GET_TARGET_VOLTAGE",
+	debugApiV3GetVersionEx: "GET_VERSION_EX",
+}
+
+var debugCommandNames = map[byte]string{
+	debugReadMem32Bit:                      "READMEM_32BIT",
+	debugWriteMem32Bit:                     "WRITEMEM_32BIT",
+	debugReadMem8Bit:                       "READMEM_8BIT",
+	debugWriteMem8Bit:                      "WRITEMEM_8BIT",
+	debugApiV1Enter:                        "APIV1_ENTER",
+	debugExit:                              "EXIT",
+	debugReadCoreId:                        "READCOREID",
+	debugApiV2Enter:                        "APIV2_ENTER",
+	debugApiV2ReadIdCodes:                  "APIV2_READ_IDCODES",
+	debugApiV2GetLastRWStatus:              "APIV2_GETLASTRWSTATUS",
+	debugApiV2DriveNrst:                    "APIV2_DRIVE_NRST",
+	debugApiV2GetLastRWStatus2:             "APIV2_GETLASTRWSTATUS2",
+	debugApiV2StartTraceRx:                 "APIV2_START_TRACE_RX",
+	debugApiV2StopTraceRx:                  "APIV2_STOP_TRACE_RX",
+	debugApiV2GetTraceNB:                   "APIV2_GET_TRACE_NB",
+	debugApiV2SwdSetFreq:                   "APIV2_SWD_SET_FREQ",
+	debugApiV2JTagSetFreq:                  "APIV2_JTAG_SET_FREQ",
+	debugApiV2ReadDebugAccessPortRegister:  "APIV2_READ_DAP_REG",
+	debugApiV2WriteDebugAccessPortRegister: "APIV2_WRITE_DAP_REG",
+	debugApiV2ReadMem16Bit:                 "APIV2_READMEM_16BIT",
+	debugApiV2WriteMem16Bit:                "APIV2_WRITEMEM_16BIT",
+	debugApiV2InitAccessPort:               "APIV2_INIT_AP",
+	debugApiV2CloseAccessPortDbg:           "APIV2_CLOSE_AP_DBG",
+	debugApiV3SetComFreq:                   "APIV3_SET_COM_FREQ",
+	debugApiV3GetComFreq:                   "APIV3_GET_COM_FREQ",
+}
+
+// SetDryRun makes the handle log every command instead of sending it. All
+// responses are synthetic (zero filled) and no error is reported by the
+// st-link, so read values are meaningless.
+func (h *StLink) SetDryRun(dryRun bool) {
+	h.dryRun = dryRun
+}
+
+// decodes the opcode of a command for logging
+func commandName(cmd []byte) string {
+	if len(cmd) == 0 {
+		return "EMPTY"
+	}
+
+	name, ok := commandNames[cmd[0]]
+
+	if !ok {
+		return fmt.Sprintf("UNKNOWN_%02X", cmd[0])
+	}
+
+	if cmd[0] == cmdDebug && len(cmd) > 1 {
+		if debugName, ok := debugCommandNames[cmd[1]]; ok {
+			name += " " + debugName
+		} else {
+			name += fmt.Sprintf(" UNKNOWN_%02X", cmd[1])
+		}
+	}
+
+	return name
+}
+
+// logs the transfer and fills the expected response with zeros
+func (h *StLink) dryRunTransfer(ctx *transferCtx, dataLength uint32) {
+	cmd := ctx.cmdBuf.Bytes()[:ctx.cmdSize]
+
+	logger.Infof("[dry run] %s cmd: % x", commandName(cmd), cmd)
+
+	if ctx.direction == transferOutgoing && dataLength > 0 {
+		logger.Infof("[dry run] data: % x", ctx.dataBuf.Bytes()[:dataLength])
+
+	} else if ctx.direction == transferIncoming && dataLength > 0 {
+		logger.Infof("[dry run] synthetic response of %d zero bytes", dataLength)
+
+		ctx.dataBuf.Write(make([]byte, dataLength))
+		ctx.received = dataLength
+	}
+}
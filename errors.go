@@ -5,9 +5,36 @@
 package gostlink
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrTraceOverflow is returned together with the read data if the trace buffer
+// of the st-link was full, trace bytes have been lost since the last poll
+var ErrTraceOverflow = errors.New("st-link trace buffer overflow, trace data lost")
+
+// ErrRttNotInitialized is returned by rtt functions called before a control
+// block was found by InitializeRtt
+var ErrRttNotInitialized = errors.New("rtt not initialized, call InitializeRtt first")
+
+// ErrMem16Unsupported is returned for 16bit memory accesses in strict access
+// width mode if the st-link firmware has no 16bit memory commands
+var ErrMem16Unsupported = errors.New("16bit memory access not supported by st-link firmware")
+
+// ErrUnsupported is returned for operations the st-link firmware provides no
+// command for
+var ErrUnsupported = errors.New("operation not supported by st-link firmware")
+
+// ErrProbeDisconnected is returned once the st-link was removed from usb, the
+// handle can not be used anymore and has to be closed
+var ErrProbeDisconnected = errors.New("st-link disconnected")
+
+// ErrHaltTimeout is returned by WaitHalted if the core did not halt in time
+var ErrHaltTimeout = errors.New("timeout waiting for core to halt")
+
+// a zero reference reading is a measurement failure, not an unpowered target
+var errVoltageReference = errors.New("voltage reference read failed")
+
 type usbErrorCode int
 
 const (
@@ -16,6 +43,9 @@ const (
 	usbErrorFail                               = -2
 	usbErrorTargetUnalignedAccess              = -3
 	usbErrorCommandNotFound                    = -4
+	usbErrorWriteVerify                        = -5
+	usbErrorBadAccessPort                      = -6
+	usbErrorAccessPortFault                    = -7
 )
 
 type usbError struct {
@@ -31,15 +61,41 @@ func newUsbError(msg string, code usbErrorCode) error {
 	return &usbError{msg, code}
 }
 
+func isWaitError(err error) bool {
+	usbErr, ok := err.(*usbError)
+
+	return ok && usbErr.UsbErrorCode == usbErrorWait
+}
+
+func isWriteVerifyError(err error) bool {
+	usbErr, ok := err.(*usbError)
+
+	return ok && usbErr.UsbErrorCode == usbErrorWriteVerify
+}
+
 /**
   Converts an STLINK status code held in the first byte of a response
   to an gostlink library error, logs any error/wait status as debug output.
 */
 func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
+	if len(ctx.DataBytes()) == 0 {
+		return newUsbError("missing STLINK status byte", usbErrorFail)
+	}
 
 	errorStatus := ctx.DataBytes()[0]
+	err := statusError(errorStatus, h.stMode, h.version.jtagApi, h.verifyWrites)
+
+	if usbErr, ok := err.(*usbError); ok && usbErr.UsbErrorCode == usbErrorOK {
+		logger.Warn(usbErr.errorString)
+	}
+
+	return err
+}
 
-	if h.stMode == StLinkModeDebugSwim {
+// maps the status byte of a st-link response to an error, independent of the
+// handle so the mapping can be checked without hardware
+func statusError(errorStatus byte, mode StLinkMode, jtagApi stLinkApiVersion, verifyWrites bool) error {
+	if mode == StLinkModeDebugSwim {
 		switch errorStatus {
 		case swimErrorOk:
 			return nil
@@ -53,7 +109,7 @@ func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
 	}
 
 	/* TODO: no error checking yet on api V1 */
-	if h.version.jtagApi == jTagApiV1 {
+	if jtagApi == jTagApiV1 {
 		errorStatus = debugErrorOk
 	}
 
@@ -77,6 +133,10 @@ func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
 		return newUsbError("Write error", usbErrorFail)
 
 	case jTagWriteVerifyError:
+		if verifyWrites {
+			return newUsbError("Write verify error", usbErrorWriteVerify)
+		}
+
 		return newUsbError("Write verify error, ignoring", usbErrorOK)
 
 	case swdAccessPortFault:
@@ -85,7 +145,8 @@ func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
 		 * Change in error status when reading outside RAM.
 		 * This fix allows CDT plugin to visualize memory.
 		 */
-		return newUsbError("STLINK_SWD_AP_FAULT", usbErrorFail)
+		return newUsbError("STLINK_SWD_AP_FAULT: bus fault on memory access, "+
+			"the address is not mapped or the peripheral is not clocked", usbErrorAccessPortFault)
 
 	case swdAccessPortError:
 		return newUsbError("STLINK_SWD_AP_ERROR", usbErrorFail)
@@ -112,7 +173,7 @@ func (h *StLink) usbErrorCheck(ctx *transferCtx) error {
 		return newUsbError("STLINK_SWD_AP_STICKYORUN_ERROR", usbErrorFail)
 
 	case badAccessPortError:
-		return newUsbError("STLINK_BAD_AP_ERROR", usbErrorFail)
+		return newUsbError("STLINK_BAD_AP_ERROR: access port does not exist or was not initialized", usbErrorBadAccessPort)
 
 	default:
 		return newUsbError(fmt.Sprintf("unknown/unexpected STLINK status code 0x%x", errorStatus), usbErrorFail)
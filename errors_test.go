@@ -0,0 +1,110 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import "testing"
+
+// expected error codes of the debug status bytes, usbErrorOK means no error
+var debugStatusCodes = map[byte]usbErrorCode{
+	debugErrorOk:                 usbErrorOK,
+	debugErrorFault:              usbErrorFail,
+	jTagGetIdCodeError:           usbErrorFail,
+	jTagWriteError:               usbErrorFail,
+	swdAccessPortWait:            usbErrorWait,
+	swdAccessPortFault:           usbErrorAccessPortFault,
+	swdAccessPortError:           usbErrorFail,
+	swdAccessPortParityError:     usbErrorFail,
+	swdDebugPortWait:             usbErrorWait,
+	swdDebugPortFault:            usbErrorFail,
+	swdDebugPortError:            usbErrorFail,
+	swdDebugPortParityError:      usbErrorFail,
+	swdAccessPortWDataError:      usbErrorFail,
+	swdAccessPortStickyError:     usbErrorFail,
+	swdAccessPortStickOrRunError: usbErrorFail,
+	badAccessPortError:           usbErrorBadAccessPort,
+	0x42:                         usbErrorFail, // unknown
+}
+
+func checkStatusError(t *testing.T, name string, err error, want usbErrorCode) {
+	t.Helper()
+
+	if want == usbErrorOK {
+		if err != nil {
+			t.Errorf("%s: got %v, want no error", name, err)
+		}
+
+		return
+	}
+
+	usbErr, ok := err.(*usbError)
+
+	if !ok {
+		t.Errorf("%s: got %v, want usb error %d", name, err, want)
+		return
+	}
+
+	if usbErr.UsbErrorCode != want {
+		t.Errorf("%s: got code %d (%v), want %d", name, usbErr.UsbErrorCode, err, want)
+	}
+}
+
+func TestStatusErrorDebug(t *testing.T) {
+	for _, api := range []stLinkApiVersion{jTagApiV2, jTagApiV3} {
+		for _, mode := range []StLinkMode{StLinkModeDebugSwd, StLinkModeDebugJtag} {
+			for status, want := range debugStatusCodes {
+				for _, verify := range []bool{false, true} {
+					err := statusError(status, mode, api, verify)
+					checkStatusError(t, "status", err, want)
+				}
+			}
+		}
+	}
+}
+
+func TestStatusErrorWriteVerify(t *testing.T) {
+	for _, api := range []stLinkApiVersion{jTagApiV2, jTagApiV3} {
+		checkStatusError(t, "verify on", statusError(jTagWriteVerifyError, StLinkModeDebugSwd, api, true), usbErrorWriteVerify)
+
+		// without verification the error still surfaces, but flagged as ok
+		err := statusError(jTagWriteVerifyError, StLinkModeDebugSwd, api, false)
+
+		if usbErr, ok := err.(*usbError); !ok || usbErr.UsbErrorCode != usbErrorOK {
+			t.Errorf("verify off: got %v, want usb error with ok code", err)
+		}
+	}
+}
+
+// api V1 has no error checking, every status is taken as ok
+func TestStatusErrorApiV1(t *testing.T) {
+	for status := range debugStatusCodes {
+		for _, verify := range []bool{false, true} {
+			checkStatusError(t, "api V1", statusError(status, StLinkModeDebugSwd, jTagApiV1, verify), usbErrorOK)
+		}
+	}
+
+	checkStatusError(t, "api V1 verify", statusError(jTagWriteVerifyError, StLinkModeDebugSwd, jTagApiV1, true), usbErrorOK)
+}
+
+// swim uses its own status codes, the debug ones are unknown there
+func TestStatusErrorSwim(t *testing.T) {
+	for _, api := range []stLinkApiVersion{jTagApiV1, jTagApiV2, jTagApiV3} {
+		checkStatusError(t, "swim ok", statusError(swimErrorOk, StLinkModeDebugSwim, api, false), usbErrorOK)
+		checkStatusError(t, "swim busy", statusError(swimErrorBusy, StLinkModeDebugSwim, api, false), usbErrorWait)
+
+		for status := range debugStatusCodes {
+			checkStatusError(t, "swim", statusError(status, StLinkModeDebugSwim, api, true), usbErrorFail)
+		}
+	}
+}
+
+func TestUsbErrorCheck(t *testing.T) {
+	h := newFakeHandle(newFakeStLink(fakeRamStart, 0x100))
+
+	ctx := h.initTransfer(transferIncoming)
+	checkStatusError(t, "missing status", h.usbErrorCheck(ctx), usbErrorFail)
+
+	ctx.dataBuf.WriteByte(swdAccessPortWait)
+	checkStatusError(t, "wait", h.usbErrorCheck(ctx), usbErrorWait)
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/boljen/go-bitmap"
+)
+
+// fakeStLink emulates the memory commands of a V2 st-link in front of a target
+// with a single ram region, it is plugged into a handle as usb transport
+type fakeStLink struct {
+	mu sync.Mutex
+
+	ramStart uint32
+	ram      []byte
+
+	// answers a command before the emulation, ok false falls through to it
+	handler func(cmd []byte) (response []byte, ok bool)
+
+	commands   [][]byte // all command stages received
+	pendingCmd []byte   // memory write waiting for its data stage
+	response   []byte   // answer to the last command, returned by the next read
+	rwStatus   byte     // status of the last memory access
+	readErr    error    // returned by all endpoint reads if set
+
+	trace      []byte // buffered trace data
+	traceReads []int  // sizes requested from the trace endpoint
+}
+
+func newFakeStLink(ramStart uint32, ramSize int) *fakeStLink {
+	return &fakeStLink{ramStart: ramStart, ram: make([]byte, ramSize), rwStatus: debugErrorOk}
+}
+
+// returns a handle connected over swd to the fake st-link (V2J37 firmware)
+func newFakeHandle(f *fakeStLink) *StLink {
+	h := &StLink{transport: f}
+
+	h.stMode = StLinkModeDebugSwd
+	h.version.stlink = 2
+	h.version.jtag = 37
+	h.version.jtagApi = jTagApiV2
+	h.version.flags = bitmap.New(32)
+
+	for _, flag := range []int{flagHasTrace, flagHasGetLastRwStatus2, flagHasMem16Bit, flagHasApInit, flagHasDapReg} {
+		h.version.flags.Set(flag, true)
+	}
+
+	h.maxMemPacket = 1 << 10
+	h.openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
+	h.retryPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond}
+
+	return h
+}
+
+func (f *fakeStLink) write(parent context.Context, buffer []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := parent.Err(); err != nil {
+		return -1, err
+	}
+
+	if f.pendingCmd != nil {
+		f.store(convertToUint32(f.pendingCmd[2:], littleEndian), buffer)
+		f.pendingCmd = nil
+
+		return len(buffer), nil
+	}
+
+	cmd := append([]byte{}, buffer...)
+	f.commands = append(f.commands, cmd)
+
+	if f.handler != nil {
+		if response, ok := f.handler(cmd); ok {
+			f.response = response
+			return len(buffer), nil
+		}
+	}
+
+	f.response = []byte{debugErrorOk, 0}
+
+	if len(cmd) < 2 || cmd[0] != cmdDebug {
+		return len(buffer), nil
+	}
+
+	switch cmd[1] {
+	case debugReadMem32Bit, debugApiV2ReadMem16Bit, debugReadMem8Bit:
+		length := int(convertToUint16(cmd[6:], littleEndian))
+		f.response = f.load(convertToUint32(cmd[2:], littleEndian), length)
+
+		// a single byte is answered with a two byte packet
+		if cmd[1] == debugReadMem8Bit && length == 1 {
+			f.response = append(f.response, 0)
+		}
+
+	case debugWriteMem32Bit, debugApiV2WriteMem16Bit, debugWriteMem8Bit:
+		f.pendingCmd = cmd
+		f.response = nil
+
+	case debugApiV2GetLastRWStatus2:
+		f.response = make([]byte, 12)
+		f.response[0] = f.rwStatus
+		f.rwStatus = debugErrorOk
+
+	case debugApiV2GetLastRWStatus:
+		f.response = []byte{f.rwStatus, 0}
+		f.rwStatus = debugErrorOk
+
+	case debugApiV2GetTraceNB:
+		f.response = []byte{byte(len(f.trace)), byte(len(f.trace) >> 8)}
+	}
+
+	return len(buffer), nil
+}
+
+func (f *fakeStLink) read(parent context.Context, buffer []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := parent.Err(); err != nil {
+		return -1, err
+	}
+
+	if f.readErr != nil {
+		return -1, f.readErr
+	}
+
+	n := copy(buffer, f.response)
+	f.response = nil
+
+	return n, nil
+}
+
+func (f *fakeStLink) readTrace(parent context.Context, buffer []byte, timeout time.Duration) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.traceReads = append(f.traceReads, len(buffer))
+
+	n := copy(buffer, f.trace)
+	f.trace = f.trace[n:]
+
+	return n, nil
+}
+
+// memory outside of the ram region reads as zero and fails with an ap fault
+func (f *fakeStLink) inRam(addr uint32, n int) bool {
+	return addr >= f.ramStart && uint64(addr-f.ramStart)+uint64(n) <= uint64(len(f.ram))
+}
+
+func (f *fakeStLink) load(addr uint32, n int) []byte {
+	data := make([]byte, n)
+
+	if !f.inRam(addr, n) {
+		f.rwStatus = swdAccessPortFault
+		return data
+	}
+
+	copy(data, f.ram[addr-f.ramStart:])
+
+	return data
+}
+
+func (f *fakeStLink) store(addr uint32, data []byte) {
+	if !f.inRam(addr, len(data)) {
+		f.rwStatus = swdAccessPortFault
+		return
+	}
+
+	copy(f.ram[addr-f.ramStart:], data)
+}
+
+// direct access to the target memory for the tests, bypassing the st-link
+func (f *fakeStLink) poke(addr uint32, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	copy(f.ram[addr-f.ramStart:], data)
+}
+
+func (f *fakeStLink) peek(addr uint32, n int) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]byte{}, f.ram[addr-f.ramStart:addr-f.ramStart+uint32(n)]...)
+}
+
+func (f *fakeStLink) pokeUint32(addr uint32, value uint32) {
+	f.poke(addr, []byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)})
+}
+
+func (f *fakeStLink) peekUint32(addr uint32) uint32 {
+	return convertToUint32(f.peek(addr, 4), littleEndian)
+}
+
+func (f *fakeStLink) commandCount(debugCmd byte) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+
+	for _, cmd := range f.commands {
+		if len(cmd) > 1 && cmd[0] == cmdDebug && cmd[1] == debugCmd {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// system control block fault status registers, CFSR to AFSR are consecutive
+const (
+	cfsrRegister = 0xE000ED28
+
+	faultRegisterCount = 6 // CFSR, HFSR, DFSR, MMFAR, BFAR, AFSR
+
+	cfsrIAccViol     = 1 << 0
+	cfsrDAccViol     = 1 << 1
+	cfsrMUnstkErr    = 1 << 3
+	cfsrMStkErr      = 1 << 4
+	cfsrMLspErr      = 1 << 5
+	cfsrMmarValid    = 1 << 7
+	cfsrIBusErr      = 1 << 8
+	cfsrPreciseErr   = 1 << 9
+	cfsrImpreciseErr = 1 << 10
+	cfsrUnstkErr     = 1 << 11
+	cfsrStkErr       = 1 << 12
+	cfsrLspErr       = 1 << 13
+	cfsrBfarValid    = 1 << 15
+	cfsrUndefInstr   = 1 << 16
+	cfsrInvState     = 1 << 17
+	cfsrInvPc        = 1 << 18
+	cfsrNoCp         = 1 << 19
+	cfsrStkOf        = 1 << 20
+	cfsrUnaligned    = 1 << 24
+	cfsrDivByZero    = 1 << 25
+
+	hfsrVectTbl  = 1 << 1
+	hfsrForced   = 1 << 30
+	hfsrDebugEvt = 1 << 31
+
+	dfsrHalted   = 1 << 0
+	dfsrBkpt     = 1 << 1
+	dfsrDwtTrap  = 1 << 2
+	dfsrVCatch   = 1 << 3
+	dfsrExternal = 1 << 4
+)
+
+// decoded fault status registers of the system control block
+type FaultStatus struct {
+	Cfsr  uint32
+	Hfsr  uint32
+	Dfsr  uint32
+	Afsr  uint32
+	Mmfar uint32 // memmanage fault address, valid if MmarValid is set
+	Bfar  uint32 // bus fault address, valid if BfarValid is set
+
+	// memmanage faults (MMFSR)
+	InstructionAccessViolation bool
+	DataAccessViolation        bool
+	MemManageUnstacking        bool
+	MemManageStacking          bool
+	MemManageLazyFpState       bool
+	MmarValid                  bool
+
+	// bus faults (BFSR)
+	InstructionBusError bool
+	PreciseBusError     bool
+	ImpreciseBusError   bool
+	BusFaultUnstacking  bool
+	BusFaultStacking    bool
+	BusFaultLazyFpState bool
+	BfarValid           bool
+
+	// usage faults (UFSR)
+	UndefinedInstruction bool
+	InvalidState         bool // e.g. thumb bit cleared in a branch target
+	InvalidPc            bool // bad EXC_RETURN value
+	NoCoprocessor        bool // e.g. fpu instruction with disabled fpu
+	StackOverflow        bool // armv8-m stack limit violation
+	Unaligned            bool
+	DivideByZero         bool
+
+	// hard faults (HFSR)
+	VectorTableRead bool
+	Forced          bool // escalated configurable fault, see the CFSR bits
+	DebugEvent      bool
+
+	// debug events (DFSR)
+	Halted          bool
+	Breakpoint      bool
+	Watchpoint      bool
+	VectorCatch     bool
+	ExternalRequest bool
+}
+
+func decodeFaultStatus(cfsr, hfsr, dfsr, mmfar, bfar, afsr uint32) FaultStatus {
+	return FaultStatus{
+		Cfsr:  cfsr,
+		Hfsr:  hfsr,
+		Dfsr:  dfsr,
+		Afsr:  afsr,
+		Mmfar: mmfar,
+		Bfar:  bfar,
+
+		InstructionAccessViolation: (cfsr & cfsrIAccViol) > 0,
+		DataAccessViolation:        (cfsr & cfsrDAccViol) > 0,
+		MemManageUnstacking:        (cfsr & cfsrMUnstkErr) > 0,
+		MemManageStacking:          (cfsr & cfsrMStkErr) > 0,
+		MemManageLazyFpState:       (cfsr & cfsrMLspErr) > 0,
+		MmarValid:                  (cfsr & cfsrMmarValid) > 0,
+
+		InstructionBusError: (cfsr & cfsrIBusErr) > 0,
+		PreciseBusError:     (cfsr & cfsrPreciseErr) > 0,
+		ImpreciseBusError:   (cfsr & cfsrImpreciseErr) > 0,
+		BusFaultUnstacking:  (cfsr & cfsrUnstkErr) > 0,
+		BusFaultStacking:    (cfsr & cfsrStkErr) > 0,
+		BusFaultLazyFpState: (cfsr & cfsrLspErr) > 0,
+		BfarValid:           (cfsr & cfsrBfarValid) > 0,
+
+		UndefinedInstruction: (cfsr & cfsrUndefInstr) > 0,
+		InvalidState:         (cfsr & cfsrInvState) > 0,
+		InvalidPc:            (cfsr & cfsrInvPc) > 0,
+		NoCoprocessor:        (cfsr & cfsrNoCp) > 0,
+		StackOverflow:        (cfsr & cfsrStkOf) > 0,
+		Unaligned:            (cfsr & cfsrUnaligned) > 0,
+		DivideByZero:         (cfsr & cfsrDivByZero) > 0,
+
+		VectorTableRead: (hfsr & hfsrVectTbl) > 0,
+		Forced:          (hfsr & hfsrForced) > 0,
+		DebugEvent:      (hfsr & hfsrDebugEvt) > 0,
+
+		Halted:          (dfsr & dfsrHalted) > 0,
+		Breakpoint:      (dfsr & dfsrBkpt) > 0,
+		Watchpoint:      (dfsr & dfsrDwtTrap) > 0,
+		VectorCatch:     (dfsr & dfsrVCatch) > 0,
+		ExternalRequest: (dfsr & dfsrExternal) > 0,
+	}
+}
+
+// ReadFaultStatus reads and decodes the fault status and fault address
+// registers (0xE000ED28 - 0xE000ED3C) in a single transfer. The registers are
+// sticky, they keep the cause until the target clears them or is reset.
+func (h *StLink) ReadFaultStatus() (FaultStatus, error) {
+	regs, err := h.readMemU32Slice(cfsrRegister, faultRegisterCount, littleEndian)
+
+	if err != nil {
+		return FaultStatus{}, err
+	}
+
+	status := decodeFaultStatus(regs[0], regs[1], regs[2], regs[3], regs[4], regs[5])
+
+	logger.Debugf("fault status CFSR: %08x HFSR: %08x DFSR: %08x", status.Cfsr, status.Hfsr, status.Dfsr)
+
+	return status, nil
+}
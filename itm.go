@@ -0,0 +1,230 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+// packet format according to ARMv7-M architecture reference manual, appendix D4
+
+package gostlink
+
+import (
+	"time"
+)
+
+type ItmPacketType int
+
+const (
+	ItmPacketSync            ItmPacketType = 0 // synchronization packet
+	ItmPacketOverflow                      = 1 // itm fifo overflow, packets were lost
+	ItmPacketInstrumentation               = 2 // software source packet (stimulus port)
+	ItmPacketHardware                      = 3 // hardware source packet (DWT)
+	ItmPacketLocalTimestamp                = 4 // local timestamp, Timestamp holds the running cycle count
+	ItmPacketGlobalTimestamp               = 5 // global timestamp, Timestamp holds the reconstructed counter
+)
+
+type TimestampPacket struct {
+	Cycles uint64    // reconstructed timestamp in trace clock cycles
+	Host   time.Time // estimated host wall-clock time of the timestamp
+}
+
+type ItmPacket struct {
+	Type      ItmPacketType
+	Port      uint8  // stimulus port or hardware source id of source packets
+	Payload   []byte // payload of source packets
+	Timestamp TimestampPacket
+}
+
+type itmTimestampAnchor struct {
+	valid  bool
+	cycles uint64
+	host   time.Time
+}
+
+// ItmDecoder splits a raw SWO byte stream into ITM packets. Packets split over
+// several Decode calls are reassembled.
+type ItmDecoder struct {
+	timestampHz uint32
+
+	pending []byte
+
+	localCycles  uint64
+	globalCycles uint64
+
+	localAnchor  itmTimestampAnchor
+	globalAnchor itmTimestampAnchor
+}
+
+// NewItmDecoder creates a decoder mapping timestamps to host time based on the
+// trace clock passed to ConfigTrace (timestamp prescaler of 1 assumed)
+func (h *StLink) NewItmDecoder() *ItmDecoder {
+	return &ItmDecoder{timestampHz: h.trace.clockInHz}
+}
+
+func (d *ItmDecoder) Decode(data []byte) []ItmPacket {
+	var packets []ItmPacket
+
+	d.pending = append(d.pending, data...)
+
+	for len(d.pending) > 0 {
+		consumed, packet, valid := d.nextPacket(d.pending)
+
+		if consumed == 0 {
+			// packet not complete yet
+			break
+		}
+
+		d.pending = d.pending[consumed:]
+
+		if valid {
+			packets = append(packets, packet)
+		}
+	}
+
+	d.pending = append([]byte{}, d.pending...)
+
+	return packets
+}
+
+func (d *ItmDecoder) nextPacket(buf []byte) (int, ItmPacket, bool) {
+	header := buf[0]
+
+	switch {
+	case header == 0x00:
+		zeros := 0
+
+		for zeros < len(buf) && buf[zeros] == 0x00 {
+			zeros++
+		}
+
+		if zeros == len(buf) {
+			return 0, ItmPacket{}, false
+		}
+
+		if zeros >= 5 && buf[zeros] == 0x80 {
+			return zeros + 1, ItmPacket{Type: ItmPacketSync}, true
+		}
+
+		return zeros, ItmPacket{}, false
+
+	case header == 0x70:
+		return 1, ItmPacket{Type: ItmPacketOverflow}, true
+
+	case (header&0x0f) == 0x00 && (header&0xc0) != 0x80:
+		// 0x80 to 0xb0 are reserved, format 1 has bit 6 set
+		var delta uint64
+		consumed := 1
+
+		if (header & 0x80) == 0 {
+			delta = uint64((header >> 4) & 0x07)
+		} else {
+			value, n := decodeItmContinuation(buf[1:], 4)
+
+			if n == 0 {
+				return 0, ItmPacket{}, false
+			}
+
+			delta = value
+			consumed += n
+		}
+
+		d.localCycles += delta
+
+		return consumed, ItmPacket{
+			Type:      ItmPacketLocalTimestamp,
+			Timestamp: d.timestamp(&d.localAnchor, d.localCycles),
+		}, true
+
+	case header == 0x94 || header == 0xb4:
+		maxBytes := 4
+
+		if header == 0xb4 {
+			maxBytes = 6
+		}
+
+		value, n := decodeItmContinuation(buf[1:], maxBytes)
+
+		if n == 0 {
+			return 0, ItmPacket{}, false
+		}
+
+		if header == 0x94 {
+			d.globalCycles = (d.globalCycles &^ 0x3ffffff) | (value & 0x3ffffff)
+		} else {
+			d.globalCycles = (d.globalCycles & 0x3ffffff) | (value << 26)
+		}
+
+		return n + 1, ItmPacket{
+			Type:      ItmPacketGlobalTimestamp,
+			Timestamp: d.timestamp(&d.globalAnchor, d.globalCycles),
+		}, true
+
+	case (header & 0x0b) == 0x08:
+		// extension packet, not needed for decoding
+		if (header & 0x80) == 0 {
+			return 1, ItmPacket{}, false
+		}
+
+		_, n := decodeItmContinuation(buf[1:], 4)
+
+		if n == 0 {
+			return 0, ItmPacket{}, false
+		}
+
+		return n + 1, ItmPacket{}, false
+
+	case (header & 0x03) != 0:
+		size := [...]int{0, 1, 2, 4}[header&0x03]
+
+		if len(buf) < size+1 {
+			return 0, ItmPacket{}, false
+		}
+
+		packet := ItmPacket{
+			Type:    ItmPacketInstrumentation,
+			Port:    header >> 3,
+			Payload: append([]byte{}, buf[1:size+1]...),
+		}
+
+		if (header & 0x04) > 0 {
+			packet.Type = ItmPacketHardware
+		}
+
+		return size + 1, packet, true
+
+	default:
+		logger.Tracef("skipping reserved itm header %02x", header)
+		return 1, ItmPacket{}, false
+	}
+}
+
+func (d *ItmDecoder) timestamp(anchor *itmTimestampAnchor, cycles uint64) TimestampPacket {
+	now := time.Now()
+
+	// the counter going backwards (e.g. a target reset) starts a new anchor
+	if !anchor.valid || d.timestampHz == 0 || cycles < anchor.cycles {
+		anchor.valid = true
+		anchor.cycles = cycles
+		anchor.host = now
+
+		return TimestampPacket{Cycles: cycles, Host: now}
+	}
+
+	elapsed := time.Duration(float64(cycles-anchor.cycles) * float64(time.Second) / float64(d.timestampHz))
+
+	return TimestampPacket{Cycles: cycles, Host: anchor.host.Add(elapsed)}
+}
+
+// decodes up to maxBytes continuation encoded bytes (7 bit payload each),
+// returns the number of consumed bytes or 0 if the packet is not complete
+func decodeItmContinuation(buf []byte, maxBytes int) (uint64, int) {
+	var value uint64
+
+	for i := 0; i < len(buf) && i < maxBytes; i++ {
+		value |= uint64(buf[i]&0x7f) << (7 * uint(i))
+
+		if (buf[i]&0x80) == 0 || i == maxBytes-1 {
+			return value, i + 1
+		}
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestItmDecodeSourcePackets(t *testing.T) {
+	d := &ItmDecoder{}
+
+	packets := d.Decode([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x80, // sync
+		0x01, 'a', // port 0, 1 byte
+		0x0a, 0x34, 0x12, // port 1, 2 bytes
+		0x73, 1, 2, 3, 4, // port 14, 4 bytes
+		0x0d, 0x42, // hardware source 1, 1 byte
+		0x70, // overflow
+	})
+
+	want := []ItmPacket{
+		{Type: ItmPacketSync},
+		{Type: ItmPacketInstrumentation, Port: 0, Payload: []byte("a")},
+		{Type: ItmPacketInstrumentation, Port: 1, Payload: []byte{0x34, 0x12}},
+		{Type: ItmPacketInstrumentation, Port: 14, Payload: []byte{1, 2, 3, 4}},
+		{Type: ItmPacketHardware, Port: 1, Payload: []byte{0x42}},
+		{Type: ItmPacketOverflow},
+	}
+
+	if len(packets) != len(want) {
+		t.Fatalf("decoded %d packets, want %d: %+v", len(packets), len(want), packets)
+	}
+
+	for i := range want {
+		if packets[i].Type != want[i].Type || packets[i].Port != want[i].Port || !bytes.Equal(packets[i].Payload, want[i].Payload) {
+			t.Errorf("packet %d = %+v, want %+v", i, packets[i], want[i])
+		}
+	}
+}
+
+func TestItmDecodeSplitPacket(t *testing.T) {
+	d := &ItmDecoder{}
+
+	if packets := d.Decode([]byte{0x73, 1, 2}); len(packets) != 0 {
+		t.Fatalf("incomplete packet decoded: %+v", packets)
+	}
+
+	packets := d.Decode([]byte{3, 4})
+
+	if len(packets) != 1 || !bytes.Equal(packets[0].Payload, []byte{1, 2, 3, 4}) {
+		t.Errorf("reassembled packets %+v", packets)
+	}
+}
+
+func TestItmDecodeLocalTimestamps(t *testing.T) {
+	d := &ItmDecoder{}
+
+	packets := d.Decode([]byte{
+		0x30,       // format 2, delta 3
+		0xc0, 0x05, // format 1, delta 5
+		0xf0, 0x81, 0x01, // format 1, delta 129
+	})
+
+	cycles := []uint64{3, 8, 137}
+
+	if len(packets) != len(cycles) {
+		t.Fatalf("decoded %d packets, want %d", len(packets), len(cycles))
+	}
+
+	for i, packet := range packets {
+		if packet.Type != ItmPacketLocalTimestamp || packet.Timestamp.Cycles != cycles[i] {
+			t.Errorf("packet %d = %+v, want local timestamp %d", i, packet, cycles[i])
+		}
+	}
+}
+
+// 0x80, 0x90, 0xa0 and 0xb0 lack bit 6 of the format 1 local timestamp
+func TestItmDecodeReservedHeaders(t *testing.T) {
+	d := &ItmDecoder{}
+
+	for _, header := range []byte{0x80, 0x90, 0xa0, 0xb0} {
+		packets := d.Decode([]byte{header, 0x01, 'x'})
+
+		if len(packets) != 1 || packets[0].Type != ItmPacketInstrumentation || string(packets[0].Payload) != "x" {
+			t.Errorf("header 0x%02x: decoded %+v, want it skipped", header, packets)
+		}
+	}
+
+	if d.localCycles != 0 {
+		t.Errorf("reserved headers counted %d local cycles", d.localCycles)
+	}
+}
+
+func TestItmDecodeGlobalTimestamps(t *testing.T) {
+	d := &ItmDecoder{timestampHz: 1000}
+
+	packets := d.Decode([]byte{
+		0x94, 0x85, 0x00, // GTS1 low bits 5
+		0xb4, 0x01, // GTS2 high bits 1
+		0x94, 0x07, // GTS1 low bits 7
+	})
+
+	cycles := []uint64{5, 1<<26 | 5, 1<<26 | 7}
+
+	if len(packets) != len(cycles) {
+		t.Fatalf("decoded %d packets, want %d", len(packets), len(cycles))
+	}
+
+	for i, packet := range packets {
+		if packet.Type != ItmPacketGlobalTimestamp || packet.Timestamp.Cycles != cycles[i] {
+			t.Errorf("packet %d = %+v, want global timestamp 0x%x", i, packet, cycles[i])
+		}
+	}
+
+	if elapsed := packets[2].Timestamp.Host.Sub(packets[0].Timestamp.Host); elapsed != time.Duration(1<<26+2)*time.Millisecond {
+		t.Errorf("host time advanced %v", elapsed)
+	}
+}
+
+// a counter going backwards must not map to a host time far in the future
+func TestItmTimestampBackwards(t *testing.T) {
+	d := &ItmDecoder{timestampHz: 1000000}
+	anchor := itmTimestampAnchor{}
+
+	first := d.timestamp(&anchor, 5000)
+	second := d.timestamp(&anchor, 1000)
+
+	if second.Host.Before(first.Host) || second.Host.Sub(first.Host) > time.Second {
+		t.Errorf("host time moved from %v to %v", first.Host, second.Host)
+	}
+
+	third := d.timestamp(&anchor, 3000)
+
+	if elapsed := third.Host.Sub(second.Host); elapsed != 2*time.Millisecond {
+		t.Errorf("host time advanced %v after re-anchoring, want 2ms", elapsed)
+	}
+}
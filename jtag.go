@@ -0,0 +1,18 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+type JtagTap struct {
+	IdCode   uint32
+	IrLength int
+}
+
+// ScanJtagChain would return the taps of the jtag chain. The st-link firmware
+// does not provide raw IR/DR shift commands, it only talks to the arm debug
+// port it selected on mode entry, so the chain cannot be enumerated and
+// ErrUnsupported is returned. The idcode of the debug port is read by GetIdCode.
+func (h *StLink) ScanJtagChain() ([]JtagTap, error) {
+	return nil, ErrUnsupported
+}
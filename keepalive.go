@@ -0,0 +1,50 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"time"
+)
+
+// EnableKeepAlive queries the st-link mode whenever the handle was idle for
+// interval to prevent the host from suspending the probe
+func (h *StLink) EnableKeepAlive(interval time.Duration) {
+	h.DisableKeepAlive()
+
+	stop := make(chan struct{})
+	h.keepAliveStop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-ticker.C:
+				h.usbMutex.Lock()
+				idle := time.Since(h.lastTransfer)
+				h.usbMutex.Unlock()
+
+				if idle < interval {
+					continue
+				}
+
+				if _, err := h.usbCurrentMode(); err != nil {
+					logger.Warn("keep alive request failed: ", err)
+				}
+			}
+		}
+	}()
+}
+
+func (h *StLink) DisableKeepAlive() {
+	if h.keepAliveStop != nil {
+		close(h.keepAliveStop)
+		h.keepAliveStop = nil
+	}
+}
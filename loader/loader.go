@@ -0,0 +1,113 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+// Package loader writes elf images into the memory of the target
+package loader
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bbnote/gostlink"
+)
+
+// size of the zero blocks written for the part of a segment not in the file
+const zeroFillChunkSize = 1024
+
+// Segment describes a loadable segment of an elf image
+type Segment struct {
+	Address  uint32 // physical (load) address of the segment
+	FileSize uint32 // bytes taken from the image
+	MemSize  uint32 // size in memory, bytes beyond FileSize are zero filled (.bss)
+}
+
+// Target is the memory written by the loader, implemented by *gostlink.StLink
+type Target interface {
+	WriteMemFrom(addr uint32, r io.Reader, length uint32) error
+	WriteBytes(addr uint32, data []byte) error
+}
+
+var _ Target = (*gostlink.StLink)(nil)
+
+// called after a segment was written, index counts from 0 to count-1
+type ProgressCb func(index int, count int, segment Segment)
+
+// LoadElf writes all PT_LOAD segments of the elf image to the target memory
+func LoadElf(target Target, r io.ReaderAt) error {
+	return LoadElfProgress(target, r, nil)
+}
+
+// LoadElfProgress works like LoadElf and calls progress after every segment,
+// progress may be nil
+func LoadElfProgress(target Target, r io.ReaderAt, progress ProgressCb) error {
+	file, err := elf.NewFile(r)
+
+	if err != nil {
+		return fmt.Errorf("could not parse elf image: %w", err)
+	}
+
+	defer file.Close()
+
+	if file.Class != elf.ELFCLASS32 {
+		return errors.New("only 32bit elf images can be loaded")
+	}
+
+	var progs []*elf.Prog
+
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Memsz > 0 {
+			progs = append(progs, prog)
+		}
+	}
+
+	for i, prog := range progs {
+		if prog.Filesz > prog.Memsz {
+			return fmt.Errorf("segment at 0x%08x is larger in file than in memory", prog.Paddr)
+		}
+
+		segment := Segment{
+			Address:  uint32(prog.Paddr),
+			FileSize: uint32(prog.Filesz),
+			MemSize:  uint32(prog.Memsz),
+		}
+
+		if err := loadSegment(target, prog, segment); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(i, len(progs), segment)
+		}
+	}
+
+	return nil
+}
+
+// streams the file part of the segment and zero fills the rest, the segment is
+// never held in memory as a whole
+func loadSegment(target Target, prog *elf.Prog, segment Segment) error {
+	if segment.FileSize > 0 {
+		if err := target.WriteMemFrom(segment.Address, prog.Open(), segment.FileSize); err != nil {
+			return fmt.Errorf("could not write segment at 0x%08x: %w", segment.Address, err)
+		}
+	}
+
+	zeros := make([]byte, zeroFillChunkSize)
+
+	for offset := segment.FileSize; offset < segment.MemSize; offset += zeroFillChunkSize {
+		n := segment.MemSize - offset
+
+		if n > zeroFillChunkSize {
+			n = zeroFillChunkSize
+		}
+
+		if err := target.WriteBytes(segment.Address+offset, zeros[:n]); err != nil {
+			return fmt.Errorf("could not zero fill segment at 0x%08x: %w", segment.Address+offset, err)
+		}
+	}
+
+	return nil
+}
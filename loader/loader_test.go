@@ -0,0 +1,148 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package loader
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// target memory recording the size of every write
+type fakeTarget struct {
+	mem    map[uint32]byte
+	writes []int
+}
+
+func (t *fakeTarget) WriteMemFrom(addr uint32, r io.Reader, length uint32) error {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(length)))
+
+	if err != nil {
+		return err
+	}
+
+	if len(data) != int(length) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return t.WriteBytes(addr, data)
+}
+
+func (t *fakeTarget) WriteBytes(addr uint32, data []byte) error {
+	for i, b := range data {
+		t.mem[addr+uint32(i)] = b
+	}
+
+	t.writes = append(t.writes, len(data))
+
+	return nil
+}
+
+// builds a 32bit arm elf image with a PT_LOAD segment per entry of progs, the
+// file data of the segments follows the program headers
+func buildElf(t *testing.T, progs []elf.Prog32, data [][]byte) []byte {
+	const headerSize, progHeaderSize = 52, 32
+
+	header := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_ARM),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     headerSize,
+		Ehsize:    headerSize,
+		Phentsize: progHeaderSize,
+		Phnum:     uint16(len(progs)),
+		Shentsize: 40,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	offset := uint32(headerSize + progHeaderSize*len(progs))
+
+	for i := range progs {
+		progs[i].Type = uint32(elf.PT_LOAD)
+		progs[i].Off = offset
+		progs[i].Filesz = uint32(len(data[i]))
+		offset += progs[i].Filesz
+	}
+
+	var image bytes.Buffer
+
+	for _, v := range []interface{}{header, progs} {
+		if err := binary.Write(&image, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, d := range data {
+		image.Write(d)
+	}
+
+	return image.Bytes()
+}
+
+func TestLoadElf(t *testing.T) {
+	text := []byte("0123456789abcdef")
+	bss := 2*zeroFillChunkSize + 100
+
+	image := buildElf(t, []elf.Prog32{
+		{Paddr: 0x08000000, Memsz: uint32(len(text))},
+		{Paddr: 0x20000000, Memsz: uint32(4 + bss)},
+	}, [][]byte{text, {1, 2, 3, 4}})
+
+	target := &fakeTarget{mem: map[uint32]byte{}}
+
+	// the zero filled area starts dirty
+	for i := 0; i < bss; i++ {
+		target.mem[0x20000004+uint32(i)] = 0xff
+	}
+
+	var segments []Segment
+
+	err := LoadElfProgress(target, bytes.NewReader(image), func(index int, count int, segment Segment) {
+		segments = append(segments, segment)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, b := range text {
+		if target.mem[0x08000000+uint32(i)] != b {
+			t.Fatalf("text byte %d = %02x, want %02x", i, target.mem[0x08000000+uint32(i)], b)
+		}
+	}
+
+	for i, b := range []byte{1, 2, 3, 4} {
+		if target.mem[0x20000000+uint32(i)] != b {
+			t.Fatalf("data byte %d = %02x, want %02x", i, target.mem[0x20000000+uint32(i)], b)
+		}
+	}
+
+	for i := 0; i < bss; i++ {
+		if target.mem[0x20000004+uint32(i)] != 0 {
+			t.Fatalf("bss byte %d not zero filled", i)
+		}
+	}
+
+	for _, n := range target.writes {
+		if n > zeroFillChunkSize {
+			t.Errorf("write of %d bytes, want at most %d", n, zeroFillChunkSize)
+		}
+	}
+
+	want := []Segment{
+		{Address: 0x08000000, FileSize: uint32(len(text)), MemSize: uint32(len(text))},
+		{Address: 0x20000000, FileSize: 4, MemSize: uint32(4 + bss)},
+	}
+
+	if len(segments) != len(want) || segments[0] != want[0] || segments[1] != want[1] {
+		t.Errorf("progress reported segments %+v, want %+v", segments, want)
+	}
+}
@@ -5,6 +5,9 @@
 package gostlink
 
 import (
+	"fmt"
+	"io/ioutil"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -12,11 +15,46 @@ var (
 	logger *logrus.Logger = nil
 )
 
+// the library stays silent until the application installs a logger
 func init() {
 	logger = logrus.New()
+	logger.SetOutput(ioutil.Discard)
 	logger.SetLevel(logrus.InfoLevel)
 }
 
+// SetLogger installs the logger of the library. The logger is shared by all
+// handles, set it before handles are used from several goroutines.
 func SetLogger(loggerInstance *logrus.Logger) {
 	logger = loggerInstance
 }
+
+// fields identifying the handle in structured log entries
+func (h *StLink) logFields() logrus.Fields {
+	return logrus.Fields{
+		"serial": h.serialNo,
+		"mode":   h.stMode.String(),
+	}
+}
+
+// logs a failed memory access and adds the access port and address to access
+// port errors, these are usually caused by the address rather than the st-link
+func (h *StLink) memAccessError(op string, ap byte, address uint32, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	logger.WithFields(h.logFields()).WithFields(logrus.Fields{
+		"ap":      ap,
+		"address": fmt.Sprintf("0x%08x", address),
+	}).WithError(err).Debugf("memory %s failed", op)
+
+	if usbErr, ok := err.(*usbError); ok {
+		switch usbErr.UsbErrorCode {
+		case usbErrorBadAccessPort, usbErrorAccessPortFault:
+			return newUsbError(fmt.Sprintf("%s (memory %s at 0x%08x via AP %d)", usbErr.errorString, op, address, ap),
+				usbErr.UsbErrorCode)
+		}
+	}
+
+	return err
+}
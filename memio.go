@@ -0,0 +1,137 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+)
+
+type memReaderAt struct {
+	h *StLink
+}
+
+// MemReaderAt returns an io.ReaderAt on the target memory, offsets are used as
+// 32bit target addresses
+func (h *StLink) MemReaderAt() io.ReaderAt {
+	return &memReaderAt{h}
+}
+
+func (r *memReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := checkMemRange(off, len(p)); err != nil {
+		return 0, err
+	}
+
+	data, err := r.h.ReadBytes(uint32(off), uint32(len(p)))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(p, data), nil
+}
+
+func checkMemRange(off int64, n int) error {
+	if off < 0 || off > math.MaxUint32 || off+int64(n) > math.MaxUint32+1 {
+		return fmt.Errorf("memory range [%x, %x) exceeds 32bit address space", off, off+int64(n))
+	}
+
+	return nil
+}
+
+type memWriterAt struct {
+	h *StLink
+}
+
+// MemWriterAt returns an io.WriterAt on the target memory. Unaligned data is
+// written with byte access. Only ram can be written, there is no flash support.
+func (h *StLink) MemWriterAt() io.WriterAt {
+	return &memWriterAt{h}
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if err := checkMemRange(off, len(p)); err != nil {
+		return 0, err
+	}
+
+	if err := w.h.WriteBytes(uint32(off), p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// WriteMemFrom writes length bytes read from r to the target memory starting at
+// addr. The data is read and written in blocks, it is never held in memory as
+// a whole. Fails with io.ErrUnexpectedEOF if r ends early.
+func (h *StLink) WriteMemFrom(addr uint32, r io.Reader, length uint32) error {
+	if err := checkMemRange(int64(addr), int(length)); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, h.maxMemPacket)
+
+	for length > 0 {
+		// the first block ends at a block boundary, so all further blocks are aligned
+		n := h.maxMemPacket - addr%h.maxMemPacket
+
+		if n > length {
+			n = length
+		}
+
+		if _, err := io.ReadFull(r, chunk[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return fmt.Errorf("could not read data for 0x%08x: %w", addr, err)
+		}
+
+		if err := h.WriteBytes(addr, chunk[:n]); err != nil {
+			return err
+		}
+
+		addr += n
+		length -= n
+	}
+
+	return nil
+}
+
+// ReadMemTo reads length bytes starting at addr and writes them to w block by
+// block as they arrive, the first failing read or write stops the transfer
+func (h *StLink) ReadMemTo(addr uint32, length uint32, w io.Writer) error {
+	if err := checkMemRange(int64(addr), int(length)); err != nil {
+		return err
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, h.maxMemPacket))
+
+	for length > 0 {
+		n := h.maxMemPacket - addr%h.maxMemPacket
+
+		if n > length {
+			n = length
+		}
+
+		buffer.Reset()
+
+		if err := h.readBytes(context.Background(), addr, n, buffer); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(buffer.Bytes()); err != nil {
+			return fmt.Errorf("could not write data of 0x%08x: %w", addr, err)
+		}
+
+		addr += n
+		length -= n
+	}
+
+	return nil
+}
@@ -6,10 +6,16 @@ package gostlink
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 )
 
-func (h *StLink) usbReadMem8(addr uint32, len uint16, buffer *bytes.Buffer) error {
+func (h *StLink) usbReadMem8(parent context.Context, ap byte, addr uint32, len uint16, buffer *bytes.Buffer) error {
+	// nothing to transfer, skip the usb round trip
+	if len == 0 {
+		return nil
+	}
+
 	var readLen = uint32(len)
 
 	/* max 8 bit read/write is 64 bytes or 512 bytes for v3 */
@@ -17,15 +23,22 @@ func (h *StLink) usbReadMem8(addr uint32, len uint16, buffer *bytes.Buffer) erro
 		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.usbBlock()), usbErrorFail)
 	}
 
-	ctx := h.initTransfer(transferIncoming)
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return newUsbError("memory access on access port other than 0 not supported by st-link", usbErrorCommandNotFound)
+	}
+
+	ctx := h.initTransferCtx(parent, transferIncoming)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
 	ctx.cmdBuf.WriteByte(debugReadMem8Bit)
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeMemAccessPort(ctx, ap)
 
-	// we need to fix read length for single bytes
+	// firmware quirk: a single byte read is answered with a two byte packet.
+	// Reading only one byte from the endpoint would end in an usb overflow,
+	// the padding byte is stripped below.
 	if readLen == 1 {
 		readLen++
 	}
@@ -33,17 +46,25 @@ func (h *StLink) usbReadMem8(addr uint32, len uint16, buffer *bytes.Buffer) erro
 	err := h.usbTransferNoErrCheck(ctx, readLen)
 
 	if err != nil {
-		return newUsbError(fmt.Sprintf("ReadMem8 transfer error occurred"), usbErrorFail)
+		return err
+	}
 
+	// the data buffer is zero padded, only trust the bytes actually received
+	if ctx.received < uint32(len) {
+		return newUsbError(fmt.Sprintf("ReadMem8 received %d of %d bytes", ctx.received, len), usbErrorFail)
 	}
 
-	buffer.Write(ctx.DataBytes())
+	buffer.Write(ctx.DataBytes()[:len])
 
-	return h.usbGetReadWriteStatus()
+	return h.usbGetReadWriteStatus(parent)
 }
 
 /** */
-func (h *StLink) usbReadMem16(addr uint32, len uint16, buffer *bytes.Buffer) error {
+func (h *StLink) usbReadMem16(parent context.Context, ap byte, addr uint32, len uint16, buffer *bytes.Buffer) error {
+	if len == 0 {
+		return nil
+	}
+
 	if !h.version.flags.Get(flagHasMem16Bit) {
 		return newUsbError("Read16 command not supported by device", usbErrorCommandNotFound)
 	}
@@ -53,65 +74,95 @@ func (h *StLink) usbReadMem16(addr uint32, len uint16, buffer *bytes.Buffer) err
 		return newUsbError("ReadMem16 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
-	ctx := h.initTransfer(transferIncoming)
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return newUsbError("memory access on access port other than 0 not supported by st-link", usbErrorCommandNotFound)
+	}
+
+	ctx := h.initTransferCtx(parent, transferIncoming)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
 	ctx.cmdBuf.WriteByte(debugApiV2ReadMem16Bit)
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeMemAccessPort(ctx, ap)
 
 	err := h.usbTransferNoErrCheck(ctx, uint32(len))
 
 	if err != nil {
-		return newUsbError("ReadMem16 transfer error occurred", usbErrorFail)
+		return err
+	}
+
+	if ctx.received < uint32(len) {
+		return newUsbError(fmt.Sprintf("ReadMem16 received %d of %d bytes", ctx.received, len), usbErrorFail)
 	}
 
 	buffer.Write(ctx.DataBytes())
 
-	return h.usbGetReadWriteStatus()
+	return h.usbGetReadWriteStatus(parent)
 }
 
-func (h *StLink) usbReadMem32(addr uint32, len uint16, buffer *bytes.Buffer) error {
+func (h *StLink) usbReadMem32(parent context.Context, ap byte, addr uint32, len uint16, buffer *bytes.Buffer) error {
+	if len == 0 {
+		return nil
+	}
 
 	/* data must be a multiple of 4 and word aligned */
 	if ((len % 4) > 0) || ((addr % 4) > 0) {
 		return newUsbError("ReadMem32 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
-	ctx := h.initTransfer(transferIncoming)
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return newUsbError("memory access on access port other than 0 not supported by st-link", usbErrorCommandNotFound)
+	}
+
+	ctx := h.initTransferCtx(parent, transferIncoming)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
 	ctx.cmdBuf.WriteByte(debugReadMem32Bit)
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeMemAccessPort(ctx, ap)
 
 	err := h.usbTransferNoErrCheck(ctx, uint32(len))
 
 	if err != nil {
-		return newUsbError("ReadMem32 transfer error occurred", usbErrorFail)
+		return err
+	}
+
+	if ctx.received < uint32(len) {
+		return newUsbError(fmt.Sprintf("ReadMem32 received %d of %d bytes", ctx.received, len), usbErrorFail)
 	}
 
 	buffer.Write(ctx.DataBytes())
 
-	return h.usbGetReadWriteStatus()
+	return h.usbGetReadWriteStatus(parent)
 }
 
-func (h *StLink) usbWriteMem8(addr uint32, len uint16, buffer []byte) error {
+func (h *StLink) usbWriteMem8(parent context.Context, ap byte, addr uint32, len uint16, buffer []byte) error {
+	if len == 0 {
+		return nil
+	}
+
 	writeLen := uint32(len)
 
 	if writeLen > h.usbBlock() {
 		return newUsbError(fmt.Sprintf("max buffer (%d) length exceeded", h.usbBlock()), usbErrorFail)
 	}
 
-	ctx := h.initTransfer(transferOutgoing)
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return newUsbError("memory access on access port other than 0 not supported by st-link", usbErrorCommandNotFound)
+	}
+
+	ctx := h.initTransferCtx(parent, transferOutgoing)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
 	ctx.cmdBuf.WriteByte(debugWriteMem8Bit)
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeMemAccessPort(ctx, ap)
 
 	ctx.dataBuf.Write(buffer[:len])
 
@@ -121,10 +172,14 @@ func (h *StLink) usbWriteMem8(addr uint32, len uint16, buffer []byte) error {
 		return err
 	}
 
-	return h.usbGetReadWriteStatus()
+	return h.usbGetReadWriteStatus(parent)
 }
 
-func (h *StLink) usbWriteMem16(addr uint32, len uint16, buffer []byte) error {
+func (h *StLink) usbWriteMem16(parent context.Context, ap byte, addr uint32, len uint16, buffer []byte) error {
+	if len == 0 {
+		return nil
+	}
+
 	writeLen := uint32(len)
 
 	if !h.version.flags.Get(flagHasMem16Bit) {
@@ -136,13 +191,18 @@ func (h *StLink) usbWriteMem16(addr uint32, len uint16, buffer []byte) error {
 		return newUsbError("ReadMem16 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
-	ctx := h.initTransfer(transferOutgoing)
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return newUsbError("memory access on access port other than 0 not supported by st-link", usbErrorCommandNotFound)
+	}
+
+	ctx := h.initTransferCtx(parent, transferOutgoing)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
 	ctx.cmdBuf.WriteByte(debugApiV2WriteMem16Bit)
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeMemAccessPort(ctx, ap)
 
 	ctx.dataBuf.Write(buffer[:len])
 
@@ -152,10 +212,14 @@ func (h *StLink) usbWriteMem16(addr uint32, len uint16, buffer []byte) error {
 		return err
 	}
 
-	return h.usbGetReadWriteStatus()
+	return h.usbGetReadWriteStatus(parent)
 }
 
-func (h *StLink) usbWriteMem32(addr uint32, len uint16, buffer []byte) error {
+func (h *StLink) usbWriteMem32(parent context.Context, ap byte, addr uint32, len uint16, buffer []byte) error {
+	if len == 0 {
+		return nil
+	}
+
 	writeLen := uint32(len)
 
 	/* data must be a multiple of 4 and word aligned */
@@ -163,13 +227,18 @@ func (h *StLink) usbWriteMem32(addr uint32, len uint16, buffer []byte) error {
 		return newUsbError("ReadMem32 Invalid data alignment", usbErrorTargetUnalignedAccess)
 	}
 
-	ctx := h.initTransfer(transferOutgoing)
+	if ap != 0 && !h.version.flags.Get(flagHasCsw) {
+		return newUsbError("memory access on access port other than 0 not supported by st-link", usbErrorCommandNotFound)
+	}
+
+	ctx := h.initTransferCtx(parent, transferOutgoing)
 
 	ctx.cmdBuf.WriteByte(cmdDebug)
 	ctx.cmdBuf.WriteByte(debugWriteMem32Bit)
 
 	ctx.cmdBuf.WriteUint32LE(addr)
 	ctx.cmdBuf.WriteUint16LE(len)
+	h.writeMemAccessPort(ctx, ap)
 
 	ctx.dataBuf.Write(buffer[:len])
 
@@ -179,5 +248,14 @@ func (h *StLink) usbWriteMem32(addr uint32, len uint16, buffer []byte) error {
 		return err
 	}
 
-	return h.usbGetReadWriteStatus()
+	return h.usbGetReadWriteStatus(parent)
+}
+
+// appends access port and (default) csw to memory commands, older
+// firmware ignores these bytes
+func (h *StLink) writeMemAccessPort(ctx *transferCtx, ap byte) {
+	ctx.cmdBuf.WriteByte(ap)
+	ctx.cmdBuf.WriteByte(0)
+	ctx.cmdBuf.WriteByte(0)
+	ctx.cmdBuf.WriteByte(0)
 }
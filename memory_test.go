@@ -0,0 +1,196 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReadMemContext(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	f.poke(fakeRamStart, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	h := newFakeHandle(f)
+	var buffer bytes.Buffer
+
+	if err := h.ReadMemContext(context.Background(), fakeRamStart, Memory32BitBlock, 2, &buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	if data := buffer.Bytes(); !bytes.Equal(data, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("read % x", data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	commands := len(f.commands)
+
+	if err := h.ReadMemContext(ctx, fakeRamStart, Memory32BitBlock, 2, &buffer); !errors.Is(err, context.Canceled) {
+		t.Errorf("read with cancelled context returned %v", err)
+	}
+
+	if len(f.commands) != commands {
+		t.Errorf("cancelled read sent %d commands", len(f.commands)-commands)
+	}
+}
+
+func TestWriteMemContext(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	h := newFakeHandle(f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := h.WriteMemContext(ctx, fakeRamStart, Memory32BitBlock, 1, []byte{1, 2, 3, 4}); !errors.Is(err, context.Canceled) {
+		t.Errorf("write with cancelled context returned %v", err)
+	}
+
+	if value := f.peekUint32(fakeRamStart); value != 0 {
+		t.Errorf("cancelled write stored 0x%08x", value)
+	}
+
+	if err := h.WriteMemContext(context.Background(), fakeRamStart, Memory32BitBlock, 1, []byte{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	if value := f.peekUint32(fakeRamStart); value != 0x04030201 {
+		t.Errorf("stored 0x%08x, want 0x04030201", value)
+	}
+}
+
+func TestUsbReadMem8Lengths(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	f.poke(fakeRamStart, []byte{0xa5, 0x5a})
+
+	h := newFakeHandle(f)
+
+	var buffer bytes.Buffer
+
+	// nothing to read, no usb transfer
+	if err := h.usbReadMem8(context.Background(), 0, fakeRamStart, 0, &buffer); err != nil || buffer.Len() != 0 {
+		t.Errorf("zero length read returned % x, %v", buffer.Bytes(), err)
+	}
+
+	if len(f.commands) != 0 {
+		t.Errorf("zero length read sent %d commands", len(f.commands))
+	}
+
+	// a single byte is answered with two, the padding is dropped
+	if err := h.usbReadMem8(context.Background(), 0, fakeRamStart, 1, &buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buffer.Bytes(), []byte{0xa5}) {
+		t.Errorf("single byte read returned % x", buffer.Bytes())
+	}
+}
+
+func TestUsbReadMemShortResponse(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	h := newFakeHandle(f)
+
+	// the st-link answers every read with two bytes only
+	f.handler = func(cmd []byte) ([]byte, bool) {
+		switch cmd[1] {
+		case debugReadMem8Bit, debugApiV2ReadMem16Bit, debugReadMem32Bit:
+			return []byte{1, 2}, true
+		}
+
+		return nil, false
+	}
+
+	reads := []func(*bytes.Buffer) error{
+		func(b *bytes.Buffer) error { return h.usbReadMem8(context.Background(), 0, fakeRamStart, 4, b) },
+		func(b *bytes.Buffer) error { return h.usbReadMem16(context.Background(), 0, fakeRamStart, 4, b) },
+		func(b *bytes.Buffer) error { return h.usbReadMem32(context.Background(), 0, fakeRamStart, 4, b) },
+	}
+
+	for i, read := range reads {
+		var buffer bytes.Buffer
+
+		if err := read(&buffer); err == nil || buffer.Len() != 0 {
+			t.Errorf("read %d: short response returned % x, %v", i, buffer.Bytes(), err)
+		}
+	}
+}
+
+func TestReadMemDisconnected(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	f.readErr = ErrProbeDisconnected
+
+	h := newFakeHandle(f)
+
+	var buffer bytes.Buffer
+
+	// the first read hits the removed device, the second one fails fast
+	for i := 0; i < 2; i++ {
+		if err := h.ReadMem(fakeRamStart, Memory32BitBlock, 1, &buffer); !errors.Is(err, ErrProbeDisconnected) {
+			t.Errorf("read %d returned %v, want ErrProbeDisconnected", i, err)
+		}
+	}
+
+	if !h.disconnected {
+		t.Error("handle not marked disconnected")
+	}
+
+	if commands := len(f.commands); commands != 1 {
+		t.Errorf("%d commands sent, want 1", commands)
+	}
+
+	if _, err := h.ReadBytes(fakeRamStart+1, 6); !errors.Is(err, ErrProbeDisconnected) {
+		t.Errorf("ReadBytes returned %v, want ErrProbeDisconnected", err)
+	}
+}
+
+func TestReadMemContextCancelledDuringTransfer(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	h := newFakeHandle(f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the context is cancelled once the read command was sent
+	f.handler = func(cmd []byte) ([]byte, bool) {
+		if cmd[0] == cmdDebug && cmd[1] == debugReadMem32Bit {
+			cancel()
+		}
+
+		return nil, false
+	}
+
+	var buffer bytes.Buffer
+
+	if err := h.ReadMemContext(ctx, fakeRamStart, Memory32BitBlock, 4, &buffer); !errors.Is(err, context.Canceled) {
+		t.Errorf("read returned %v, want context.Canceled", err)
+	}
+
+	if buffer.Len() != 0 {
+		t.Errorf("cancelled read returned % x", buffer.Bytes())
+	}
+}
+
+func TestWriteMemContextCancelledDuringTransfer(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	h := newFakeHandle(f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f.handler = func(cmd []byte) ([]byte, bool) {
+		if cmd[0] == cmdDebug && cmd[1] == debugWriteMem32Bit {
+			cancel()
+		}
+
+		return nil, false
+	}
+
+	if err := h.WriteMemContext(ctx, fakeRamStart, Memory32BitBlock, 1, []byte{1, 2, 3, 4}); !errors.Is(err, context.Canceled) {
+		t.Errorf("write returned %v, want context.Canceled", err)
+	}
+}
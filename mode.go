@@ -6,6 +6,8 @@ package gostlink
 
 import (
 	"errors"
+
+	"github.com/boljen/go-bitmap"
 )
 
 /** */
@@ -59,6 +61,25 @@ func (h *StLink) usbModeEnter(stMode StLinkMode) error {
 	return h.usbCmdAllowRetry(ctx, rxSize)
 }
 
+// SwdLineReset enters the current debug mode again, which resends the line
+// reset and jtag-to-swd sequence without reopening the st-link or touching
+// the reset line. Access ports have to be initialized again afterwards.
+func (h *StLink) SwdLineReset() error {
+	if h.stMode != StLinkModeDebugSwd && h.stMode != StLinkModeDebugJtag {
+		return errors.New("line reset requires swd or jtag mode")
+	}
+
+	logger.Debug("resending debug mode entry sequence")
+
+	if err := h.usbModeEnter(h.stMode); err != nil {
+		return err
+	}
+
+	h.openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
+
+	return h.usbOpenAccessPort(0)
+}
+
 func (h *StLink) usbCurrentMode() (byte, error) {
 
 	ctx := h.initTransfer(transferIncoming)
@@ -74,7 +95,9 @@ func (h *StLink) usbCurrentMode() (byte, error) {
 	}
 }
 
-func (h *StLink) usbInitMode(connectUnderReset bool, initialInterfaceSpeed uint32) error {
+func (h *StLink) usbInitMode(config *StLinkInterfaceConfig) error {
+	connectUnderReset := config.connectUnderReset
+	initialInterfaceSpeed := config.initialSpeed
 
 	mode, err := h.usbCurrentMode()
 
@@ -107,7 +130,10 @@ func (h *StLink) usbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 		stLinkMode = StLinkModeUnknown
 	}
 
-	if stLinkMode != StLinkModeUnknown {
+	if !config.leaveExistingMode {
+		logger.Debug("skip leaving current usb mode")
+
+	} else if stLinkMode != StLinkModeUnknown {
 		if err = h.usbLeaveMode(stLinkMode); err != nil {
 			logger.Warn("error occured while trying to leave mode: ", err)
 		}
@@ -126,7 +152,7 @@ func (h *StLink) usbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 	 * the stlink requires the target Vdd to be connected for reliable debugging.
 	 * this cmd is supported in all modes except DFU
 	 */
-	if mode != deviceModeDFU {
+	if mode != deviceModeDFU && !config.skipVoltageCheck {
 		/* check target voltage (if supported) */
 		voltage, err := h.GetTargetVoltage()
 
@@ -134,7 +160,7 @@ func (h *StLink) usbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 			logger.Error(err)
 			// attempt to continue as it is not a catastrophic failure
 		} else {
-			if voltage < 1.5 {
+			if voltage < minimumTargetVoltage {
 				logger.Warn("target voltage may be too low for reliable debugging")
 			}
 		}
@@ -189,6 +215,8 @@ func (h *StLink) usbInitMode(connectUnderReset bool, initialInterfaceSpeed uint3
 		return err
 	}
 
+	logger.WithFields(h.logFields()).Debug("entered debug mode")
+
 	if connectUnderReset {
 		logger.Trace("Assert RST line 2")
 		err = h.usbAssertSrst(0)
@@ -0,0 +1,101 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+type MemRegion struct {
+	Address uint32
+	Size    uint32 // size in bytes
+}
+
+func (r MemRegion) end() uint64 {
+	return uint64(r.Address) + uint64(r.Size)
+}
+
+// sorts the regions and merges regions which overlap or are at most maxGap
+// bytes apart, empty regions are dropped
+func coalesceMemRegions(regions []MemRegion, maxGap uint32) []MemRegion {
+	sorted := append([]MemRegion{}, regions...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Address == sorted[j].Address {
+			return sorted[i].Size < sorted[j].Size
+		} else {
+			return sorted[i].Address < sorted[j].Address
+		}
+	})
+
+	var merged []MemRegion
+
+	for _, region := range sorted {
+		if region.Size == 0 {
+			continue
+		}
+
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+
+			if uint64(region.Address) <= last.end()+uint64(maxGap) {
+				if region.end() > last.end() {
+					last.Size = uint32(region.end() - uint64(last.Address))
+				}
+
+				continue
+			}
+		}
+
+		merged = append(merged, region)
+	}
+
+	return merged
+}
+
+// ReadRegions reads all given regions, adjacent or overlapping regions are read
+// in a single transfer. Slices of overlapping regions share their memory.
+func (h *StLink) ReadRegions(regions []MemRegion) ([][]byte, error) {
+	return h.readMemRegions(context.Background(), regions, 0)
+}
+
+func (h *StLink) readMemRegions(parent context.Context, regions []MemRegion, maxGap uint32) ([][]byte, error) {
+	merged := coalesceMemRegions(regions, maxGap)
+	chunks := make([][]byte, len(merged))
+
+	for i, region := range merged {
+		buffer := bytes.NewBuffer(make([]byte, 0, region.Size))
+
+		if err := h.readBytes(parent, region.Address, region.Size, buffer); err != nil {
+			return nil, err
+		}
+
+		chunks[i] = buffer.Bytes()
+	}
+
+	result := make([][]byte, len(regions))
+
+	for i, region := range regions {
+		result[i] = []byte{}
+
+		if region.Size == 0 {
+			continue
+		}
+
+		for j, chunk := range merged {
+			if region.Address >= chunk.Address && region.end() <= chunk.end() {
+				offset := region.Address - chunk.Address
+				result[i] = chunks[j][offset : offset+region.Size]
+				break
+			}
+		}
+	}
+
+	logger.Tracef("read %d memory regions with %d transfers", len(regions), len(merged))
+
+	return result, nil
+}
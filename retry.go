@@ -0,0 +1,62 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"time"
+)
+
+// RetryPolicy controls how often and how long commands are retried while the
+// target answers with a wait status.
+type RetryPolicy struct {
+	MaxRetries int           // retries before the wait status is returned as error
+	BaseDelay  time.Duration // delay before the first retry, doubled for every further retry
+	MaxDelay   time.Duration // upper bound for the delay between two retries
+}
+
+// DefaultRetryPolicy retries up to 8 times with a delay of 1ms doubled on each
+// retry up to 128ms
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: maximumWaitRetries,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   (1 << (maximumWaitRetries - 1)) * time.Millisecond,
+	}
+}
+
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+
+	for i := 0; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+func (h *StLink) SetRetryPolicy(policy RetryPolicy) {
+	h.retryPolicy = policy
+}
+
+func (h *StLink) RetryPolicy() RetryPolicy {
+	return h.retryPolicy
+}
+
+// backoffSleep waits the policy delay (a time.Duration, no further scaling)
+// before the given zero based retry and returns the incremented retry count
+func (h *StLink) backoffSleep(retries int) int {
+	delay := h.retryPolicy.delay(retries)
+
+	logger.Debugf("retry %d, delaying %v", retries+1, delay)
+	time.Sleep(delay)
+
+	h.countStats(0, 0, 0, 1, 0)
+
+	return retries + 1
+}
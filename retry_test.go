@@ -0,0 +1,111 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDefaultRetryPolicyDelays(t *testing.T) {
+	policy := DefaultRetryPolicy()
+
+	for retry := 0; retry < policy.MaxRetries+2; retry++ {
+		want := time.Duration(1<<uint(retry)) * time.Millisecond
+
+		if want > 128*time.Millisecond {
+			want = 128 * time.Millisecond
+		}
+
+		if delay := policy.delay(retry); delay != want {
+			t.Errorf("retry %d: delay %v, want %v", retry, delay, want)
+		}
+	}
+}
+
+func TestRetryPolicyDelayCap(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 5, BaseDelay: 3 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	want := []time.Duration{3 * time.Millisecond, 6 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}
+
+	for retry, d := range want {
+		if delay := policy.delay(retry); delay != d {
+			t.Errorf("retry %d: delay %v, want %v", retry, delay, d)
+		}
+	}
+
+	// a base delay above the cap is cut as well
+	policy.BaseDelay = time.Second
+
+	if delay := policy.delay(0); delay != policy.MaxDelay {
+		t.Errorf("delay %v, want the cap %v", delay, policy.MaxDelay)
+	}
+}
+
+func TestBackoffSleep(t *testing.T) {
+	h := newFakeHandle(newFakeStLink(fakeRamStart, 0x100))
+	h.SetRetryPolicy(RetryPolicy{MaxRetries: 3, BaseDelay: 2 * time.Millisecond, MaxDelay: 4 * time.Millisecond})
+
+	start := time.Now()
+	retries := 0
+
+	for i := 0; i < 3; i++ {
+		retries = h.backoffSleep(retries)
+	}
+
+	if retries != 3 {
+		t.Errorf("retries = %d, want 3", retries)
+	}
+
+	// 2ms, 4ms and the capped 4ms
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("slept %v, want at least 10ms", elapsed)
+	}
+
+	if stats := h.Stats(); stats.Retries != 3 {
+		t.Errorf("%d retries counted, want 3", stats.Retries)
+	}
+}
+
+// a read answered with a wait status is repeated until the policy gives up
+func TestReadMemWaitRetries(t *testing.T) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	f.pokeUint32(fakeRamStart, 0x12345678)
+
+	h := newFakeHandle(f)
+	waits := 2
+
+	f.handler = func(cmd []byte) ([]byte, bool) {
+		if cmd[0] == cmdDebug && cmd[1] == debugApiV2GetLastRWStatus2 && waits > 0 {
+			waits--
+			return append([]byte{swdAccessPortWait}, make([]byte, 11)...), true
+		}
+
+		return nil, false
+	}
+
+	var buffer bytes.Buffer
+
+	if err := h.ReadMem(fakeRamStart, Memory32BitBlock, 1, &buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() != 4 || convertToUint32(buffer.Bytes(), littleEndian) != 0x12345678 {
+		t.Errorf("read % x", buffer.Bytes())
+	}
+
+	if stats := h.Stats(); stats.Retries != 2 {
+		t.Errorf("%d retries counted, want 2", stats.Retries)
+	}
+
+	// more wait responses than retries fail the read
+	waits = h.RetryPolicy().MaxRetries + 1
+	buffer.Reset()
+
+	if err := h.ReadMem(fakeRamStart, Memory32BitBlock, 1, &buffer); !isWaitError(err) {
+		t.Errorf("read returned %v, want the wait error", err)
+	}
+}
@@ -8,16 +8,38 @@ package gostlink
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"sort"
+	"fmt"
+	"io"
+	"math"
 )
 
 type RttDataCb func(int, []byte) error
 
+// called by UpdateRttChannels for channels which came online or changed
+type RttChannelCb func(RttChannelInfo)
+
+type RttChannelInfo struct {
+	Index     int
+	Direction RttDirection
+	Name      string
+	Size      uint32 // size of the channel buffer in bytes
+	Flags     uint32
+	Pending   uint32 // bytes written to the buffer but not read yet
+}
+
 const (
 	DefaultRamStart = 0x20000000
 )
 
+type RttDirection int
+
+const (
+	RttDirectionUp   RttDirection = 0 // target to host
+	RttDirectionDown              = 1 // host to target
+)
+
 type seggerRttMode int
 
 const (
@@ -26,10 +48,34 @@ const (
 	SeggerRttModeBlockIfFifoFull               = 2
 )
 
+// block mode is stored in the low bits of the channel flags, the remaining
+// bits are reserved
+const seggerRttModeMask = 0x03
+
+type RttChannelFlags struct {
+	BlockMode seggerRttMode
+}
+
+// DecodeRttChannelFlags interprets the flags field of a channel descriptor
+func DecodeRttChannelFlags(flags uint32) RttChannelFlags {
+	return RttChannelFlags{BlockMode: seggerRttMode(flags & seggerRttModeMask)}
+}
+
+// DecodedFlags returns the decoded flags of the channel
+func (i RttChannelInfo) DecodedFlags() RttChannelFlags {
+	return DecodeRttChannelFlags(i.Flags)
+}
+
 // hold size of data structs to avoid working with sizeof (from unsafe package)
 const (
 	seggerRttBufferSize       = 24
 	seggerRttControlBlockSize = 24
+
+	seggerRttWrOffOffset = 12 // offset of wrOff in a channel descriptor
+	seggerRttRdOffOffset = 16 // offset of rdOff in a channel descriptor
+
+	rttMaxChannelNameLength = 64
+	rttNameAddressLimit     = 0x40000000 // end of the code and sram regions
 )
 
 // all data that belongs to a Segger RTT channel (up- or down stream)
@@ -41,6 +87,8 @@ type seggerRttChannel struct {
 	wrOff        uint32
 	rdOff        uint32
 	flags        uint32
+
+	channelName string // name read from target, cached while name pointer is unchanged
 }
 
 //
@@ -59,11 +107,46 @@ type seggerRttInfo struct {
 	offset       uint32
 	ramStart     uint32
 	controlBlock seggerRttControlBlock
+
+	maxDrainIterations int
+	maxReadGap         uint32 // 0 reads all up channel buffers in one transfer
+	channelCallback    RttChannelCb
+	peek               bool
+}
+
+// SetRttPeekMode makes ReadRttChannels leave the read offsets on the target
+// untouched, data is not consumed and returned again by the next read
+func (h *StLink) SetRttPeekMode(peek bool) {
+	h.seggerRtt.peek = peek
+}
+
+// SetRttChannelCallback sets a callback invoked once for every channel which
+// comes online or whose name or size changes between UpdateRttChannels calls
+func (h *StLink) SetRttChannelCallback(callback RttChannelCb) {
+	h.seggerRtt.channelCallback = callback
+}
+
+// SetRttMaxDrainIterations lets ReadRttChannels re-read the channel offsets and
+// drain again until all up channels are empty, at most iterations times per call.
+// Values below 2 drain only the data present when ReadRttChannels is called.
+func (h *StLink) SetRttMaxDrainIterations(iterations int) {
+	h.seggerRtt.maxDrainIterations = iterations
+}
+
+// SetRttMaxReadGap makes ReadRttChannels read up channel buffers separately if
+// they are more than gap bytes apart, the memory between them is not read. By
+// default (gap 0) all buffers holding data are read within a single transfer.
+func (h *StLink) SetRttMaxReadGap(gap uint32) {
+	h.seggerRtt.maxReadGap = gap
 }
 
 func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
 
 	for _, r := range rttSearchRanges {
+		if r[0] > math.MaxUint32 || r[1] > math.MaxUint32 || r[0]+r[1] > math.MaxUint32+1 {
+			return fmt.Errorf("rtt search range [%08x, %08x] exceeds 32bit address space", r[0], r[0]+r[1])
+		}
+
 		logger.Infof("searching for SeggerRTT in range  [%08x, %08x]", r[0], r[0]+r[1])
 
 		ramStart := uint32(r[0])
@@ -83,7 +166,11 @@ func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
 				h.seggerRtt.offset = uint32(occ)
 
 				logger.Infof("found RTT control block at address: 0x%08x", h.seggerRtt.ramStart+h.seggerRtt.offset)
-				parseRttControlBlock(ramBuffer.Bytes()[h.seggerRtt.offset:], &h.seggerRtt.controlBlock)
+				err = parseRttControlBlock(ramBuffer.Bytes()[h.seggerRtt.offset:], &h.seggerRtt.controlBlock, h.config.targetEndian)
+
+				if err != nil {
+					return err
+				}
 
 				if h.seggerRtt.controlBlock.maxNumDownBuffers == 0 || h.seggerRtt.controlBlock.maxNumUpBuffers == 0 {
 					return errors.New("could not find any up or downstream buffers in rtt block")
@@ -109,11 +196,19 @@ func (h *StLink) InitializeRtt(rttSearchRanges [][2]uint64) error {
 }
 
 func (h *StLink) UpdateRttChannels(readChannelNames bool) error {
+	return h.updateRttChannels(context.Background(), readChannelNames)
+}
+
+func (h *StLink) updateRttChannels(parent context.Context, readChannelNames bool) error {
+	if h.seggerRtt.controlBlock.channels == nil {
+		return ErrRttNotInitialized
+	}
+
 	bufferAmount := h.seggerRtt.controlBlock.maxNumUpBuffers + h.seggerRtt.controlBlock.maxNumDownBuffers
 	ramBuffer := bytes.NewBuffer([]byte{})
 	size := bufferAmount * seggerRttBufferSize
 
-	err := h.ReadMem(h.seggerRtt.ramStart+h.seggerRtt.offset+seggerRttControlBlockSize, 1, size, ramBuffer)
+	err := h.ReadMemContext(parent, h.seggerRtt.ramStart+h.seggerRtt.offset+seggerRttControlBlockSize, 1, size, ramBuffer)
 
 	if err == nil {
 		controlBlockOffset := uint32(0)
@@ -121,34 +216,31 @@ func (h *StLink) UpdateRttChannels(readChannelNames bool) error {
 		ramBytes := ramBuffer.Bytes()
 
 		for i := uint32(0); i < bufferAmount; i++ {
-			rttBuffer := &seggerRttChannel{}
-
-			rttBuffer.name = convertToUint32(ramBytes[controlBlockOffset:], littleEndian)
-			controlBlockOffset += 4
+			rttBuffer, err := parseRttChannel(ramBytes[controlBlockOffset:], h.config.targetEndian)
 
-			rttBuffer.buffer = convertToUint32(ramBytes[controlBlockOffset:], littleEndian)
-			controlBlockOffset += 4
+			if err != nil {
+				return err
+			}
 
-			rttBuffer.sizeOfBuffer = convertToUint32(ramBytes[controlBlockOffset:], littleEndian)
-			controlBlockOffset += 4
+			controlBlockOffset += seggerRttBufferSize
 
-			rttBuffer.wrOff = convertToUint32(ramBytes[controlBlockOffset:], littleEndian)
-			controlBlockOffset += 4
+			previous := h.seggerRtt.controlBlock.channels[i]
+			changed := previous == nil || previous.name != rttBuffer.name ||
+				previous.buffer != rttBuffer.buffer || previous.sizeOfBuffer != rttBuffer.sizeOfBuffer
 
-			rttBuffer.rdOff = convertToUint32(ramBytes[controlBlockOffset:], littleEndian)
-			controlBlockOffset += 4
+			if previous != nil && previous.name == rttBuffer.name && previous.channelName != "" {
+				rttBuffer.channelName = previous.channelName
+			} else if rttBuffer.name != 0 && (readChannelNames || h.seggerRtt.channelCallback != nil) {
+				rttBuffer.channelName = h.readRttChannelName(rttBuffer.name)
+			}
 
-			rttBuffer.flags = convertToUint32(ramBytes[controlBlockOffset:], littleEndian)
-			controlBlockOffset += 4
+			if changed && rttBuffer.sizeOfBuffer > 0 && h.seggerRtt.channelCallback != nil {
+				h.seggerRtt.channelCallback(h.rttChannelInfo(int(i), rttBuffer))
+			}
 
 			if rttBuffer.name != 0 && readChannelNames == true {
-				channelNameBuf := bytes.NewBuffer([]byte{})
-
-				h.ReadMem(rttBuffer.name, 1, 64, channelNameBuf)
-				channelName, _ := channelNameBuf.ReadString(byte(0))
-
 				logger.Debugf("%d. Channel Name: %s, \tsize: %d, flags: %d, pBuffer 0x%08x, rdOff: %d, wrOff: %d", i,
-					channelName, rttBuffer.sizeOfBuffer, rttBuffer.flags, rttBuffer.buffer, rttBuffer.rdOff, rttBuffer.wrOff)
+					rttBuffer.channelName, rttBuffer.sizeOfBuffer, rttBuffer.flags, rttBuffer.buffer, rttBuffer.rdOff, rttBuffer.wrOff)
 
 			} else {
 				//log.Debugf("%d. -------------, \tsize: %d, flags: %d, pBuffer 0x%08x,  rdOff: %d, wrOff: %d", i,
@@ -164,85 +256,248 @@ func (h *StLink) UpdateRttChannels(readChannelNames bool) error {
 	return nil
 }
 
+// ForEachUpChannel calls fn for every up channel with the state read by the
+// last UpdateRttChannels call, no target memory is accessed
+func (h *StLink) ForEachUpChannel(fn func(RttChannelInfo)) {
+	for i, channel := range h.seggerRtt.controlBlock.channels {
+		if h.channelDirection(i) != RttDirectionUp {
+			break
+		}
+
+		if channel != nil {
+			fn(h.rttChannelInfo(i, channel))
+		}
+	}
+}
+
+// RttPending reads the channel descriptors and returns the number of unread
+// bytes of each up channel
+func (h *StLink) RttPending() (map[int]uint32, error) {
+	if err := h.UpdateRttChannels(false); err != nil {
+		return nil, err
+	}
+
+	pending := make(map[int]uint32)
+
+	h.ForEachUpChannel(func(info RttChannelInfo) {
+		pending[info.Index] = info.Pending
+	})
+
+	return pending, nil
+}
+
+// RttFillPercent returns how full the buffer of channel is (index as in
+// RttChannelInfo), only the offsets of the channel are read from the target
+func (h *StLink) RttFillPercent(channel int) (float64, error) {
+	if h.seggerRtt.controlBlock.channels == nil {
+		return 0, ErrRttNotInitialized
+	}
+
+	if channel < 0 || channel >= len(h.seggerRtt.controlBlock.channels) {
+		return 0, fmt.Errorf("rtt channel %d does not exist", channel)
+	}
+
+	// the channels are read by UpdateRttChannels, not by InitializeRtt
+	if h.seggerRtt.controlBlock.channels[channel] == nil {
+		return 0, fmt.Errorf("rtt channel %d not read from target yet, call UpdateRttChannels first", channel)
+	}
+
+	size := h.seggerRtt.controlBlock.channels[channel].sizeOfBuffer
+
+	if size == 0 {
+		return 0, fmt.Errorf("rtt channel %d has no buffer", channel)
+	}
+
+	offsets, err := h.ReadMemU32Slice(h.rttChannelDescriptor(uint32(channel))+seggerRttWrOffOffset, 2)
+
+	if err != nil {
+		return 0, err
+	}
+
+	wrOff, rdOff := offsets[0], offsets[1]
+
+	if wrOff >= size || rdOff >= size {
+		return 0, errors.New("rtt channel offsets exceed channel buffer")
+	}
+
+	fill := (wrOff + size - rdOff) % size
+
+	return float64(fill) / float64(size) * 100, nil
+}
+
+func (h *StLink) rttChannelInfo(index int, channel *seggerRttChannel) RttChannelInfo {
+	info := RttChannelInfo{
+		Index:     index,
+		Direction: h.channelDirection(index),
+		Name:      channel.channelName,
+		Size:      channel.sizeOfBuffer,
+		Flags:     channel.flags,
+	}
+
+	if channel.wrOff >= channel.rdOff {
+		info.Pending = channel.wrOff - channel.rdOff
+	} else {
+		info.Pending = channel.sizeOfBuffer - channel.rdOff + channel.wrOff
+	}
+
+	return info
+}
+
+// channels of the control block are ordered up channels first, then down channels
+func (h *StLink) channelDirection(index int) RttDirection {
+	if uint32(index) < h.seggerRtt.controlBlock.maxNumUpBuffers {
+		return RttDirectionUp
+	} else {
+		return RttDirectionDown
+	}
+}
+
+func (h *StLink) readRttChannelName(address uint32) string {
+	if address == 0 {
+		return ""
+	}
+
+	// names live in flash or ram, a pointer into peripheral or system space is
+	// corrupt (e.g. a control block not yet initialized during early boot)
+	if uint64(address)+rttMaxChannelNameLength > rttNameAddressLimit {
+		logger.Debugf("skipping rtt channel name at invalid address 0x%08x", address)
+		return ""
+	}
+
+	channelName, err := h.ReadCString(address, rttMaxChannelNameLength)
+
+	// a name without terminator is used truncated
+	if err != nil && channelName == "" {
+		logger.Debugf("could not read rtt channel name at 0x%08x: %s", address, err)
+	}
+
+	return channelName
+}
+
 func (h *StLink) ReadRttChannels(callback RttDataCb) error {
+	return h.readRttChannels(context.Background(), callback, nil)
+}
+
+// RouteRtt updates the channels and drains every up channel with a writer in
+// writers into its writer, unmapped channels are left untouched on the target
+func (h *StLink) RouteRtt(writers map[int]io.Writer) error {
+	if err := h.UpdateRttChannels(false); err != nil {
+		return err
+	}
+
+	include := func(channel int) bool {
+		_, ok := writers[channel]
+		return ok
+	}
+
+	// the data is consumed on the target anyway, keep draining on writer errors
+	var writeErr error
+
+	err := h.readRttChannels(context.Background(), func(channel int, data []byte) error {
+		_, err := writers[channel].Write(data)
+
+		if err != nil && writeErr == nil {
+			writeErr = fmt.Errorf("could not write data of rtt channel %d: %w", channel, err)
+		}
+
+		return nil
+	}, include)
+
+	if err != nil {
+		return err
+	}
+
+	return writeErr
+}
+
+// reads the up channels for which include returns true, all if include is nil
+func (h *StLink) readRttChannels(parent context.Context, callback RttDataCb, include func(int) bool) error {
+	if h.seggerRtt.controlBlock.channels == nil {
+		return ErrRttNotInitialized
+	}
+
 	if h.seggerRtt.controlBlock.maxNumUpBuffers == 0 {
 		return errors.New("no channels for reading configured on target")
 	}
 
-	start := h.seggerRtt.offset
-	buffersCnt := h.seggerRtt.controlBlock.maxNumDownBuffers + h.seggerRtt.controlBlock.maxNumUpBuffers
-	size := seggerRttControlBlockSize + seggerRttBufferSize*buffersCnt
+	for i := 0; ; i++ {
+		drained, err := h.drainRttChannels(parent, callback, include)
+
+		if err != nil || drained == 0 || h.seggerRtt.peek || i+1 >= h.seggerRtt.maxDrainIterations {
+			return err
+		}
 
-	var blocks [][2]uint32
+		if err = h.updateRttChannels(parent, false); err != nil {
+			return err
+		}
+	}
+}
 
-	for _, channel := range h.seggerRtt.controlBlock.channels {
+// drains all up channels holding data, returns the number of drained channels.
+// Draining stops at the first error of the channel access or the callback.
+func (h *StLink) drainRttChannels(parent context.Context, callback RttDataCb, include func(int) bool) (int, error) {
+	var channelIdx []uint32
+	var regions []MemRegion
 
-		if channel.sizeOfBuffer > 0 && channel.rdOff != channel.wrOff {
-			start = channel.buffer - h.seggerRtt.ramStart
-			size = channel.sizeOfBuffer
+	for i, channel := range h.seggerRtt.controlBlock.channels {
+		if h.channelDirection(i) != RttDirectionUp {
+			break
+		}
+
+		if include != nil && !include(i) {
+			continue
+		}
 
-			blocks = append(blocks, [...]uint32{start, size})
+		if channel != nil && channel.sizeOfBuffer > 0 && channel.rdOff != channel.wrOff {
+			channelIdx = append(channelIdx, uint32(i))
+			regions = append(regions, MemRegion{channel.buffer, channel.sizeOfBuffer})
 		}
 	}
 
-	// now determine channel buffer ram read boundaries
-	if len(blocks) == 0 {
+	if len(regions) == 0 {
 		//log.Debug("No data to read from channel")
-		return nil
+		return 0, nil
 	}
 
-	sort.Slice(blocks, func(i, j int) bool {
-		if blocks[i][0] < blocks[j][0] {
-			return true
-		} else if blocks[i][0] == blocks[j][0] && blocks[i][1] < blocks[j][1] {
-			return true
-		} else {
-			return false
-		}
-	})
+	// all channel buffers are read within a single transfer unless a max gap is set
+	maxGap := h.seggerRtt.maxReadGap
 
-	start = blocks[0][0]
-	size = blocks[len(blocks)-1][0] + blocks[len(blocks)-1][1] - start
+	if maxGap == 0 {
+		maxGap = math.MaxUint32
+	}
 
-	ramBuffer := bytes.NewBuffer([]byte{})
-	err := h.ReadMem(h.seggerRtt.ramStart+start, Memory8BitBlock, size, ramBuffer)
+	channelBuffers, err := h.readMemRegions(parent, regions, maxGap)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	for i, channel := range h.seggerRtt.controlBlock.channels {
-		if uint32(i) >= h.seggerRtt.controlBlock.maxNumUpBuffers {
-			break
-		}
+	for i, idx := range channelIdx {
+		channelData := bytes.NewBuffer([]byte{})
 
-		if (channel.sizeOfBuffer > 0) && channel.rdOff != channel.wrOff {
-			channelData := bytes.NewBuffer([]byte{})
-			h.readDataFromRttChannelBuffer(uint32(i), ramBuffer.Bytes(), channelData)
+		if _, err = h.readDataFromRttChannelBuffer(parent, idx, channelBuffers[i], channelData); err != nil {
+			return i, err
+		}
 
-			callback(i, channelData.Bytes())
+		if err = callback(int(idx), channelData.Bytes()); err != nil {
+			return i + 1, err
 		}
 	}
 
-	return nil
+	return len(channelIdx), nil
 }
 
-func (h *StLink) readDataFromRttChannelBuffer(channelIdx uint32, ramBuffer []byte, data *bytes.Buffer) (int, error) {
+func (h *StLink) readDataFromRttChannelBuffer(parent context.Context, channelIdx uint32, channelBuffer []byte, data *bytes.Buffer) (int, error) {
 	rttBuffer := h.seggerRtt.controlBlock.channels[channelIdx]
 	wrOff := rttBuffer.wrOff
 	RdOff := rttBuffer.rdOff
 
-	// determine buffer index
-	bufferOffset := uint32(0)
-	for i, channel := range h.seggerRtt.controlBlock.channels {
-		if uint32(i) >= channelIdx {
-			break
-		}
-		bufferOffset += channel.sizeOfBuffer
+	if wrOff >= uint32(len(channelBuffer)) || RdOff >= uint32(len(channelBuffer)) {
+		return -1, errors.New("rtt channel offsets exceed channel buffer")
 	}
 
 	for RdOff != wrOff {
-		data.WriteByte(ramBuffer[bufferOffset+RdOff])
+		data.WriteByte(channelBuffer[RdOff])
 		RdOff++
 
 		if RdOff > rttBuffer.sizeOfBuffer-1 {
@@ -251,24 +506,159 @@ func (h *StLink) readDataFromRttChannelBuffer(channelIdx uint32, ramBuffer []byt
 		}
 	}
 
-	if data.Len() > 0 {
-		addressRdOff := h.seggerRtt.ramStart + h.seggerRtt.offset + seggerRttControlBlockSize + channelIdx*seggerRttBufferSize + 16 // 20 bytes rdOff pos
+	if data.Len() > 0 && !h.seggerRtt.peek {
+		addressRdOff := h.rttChannelDescriptor(channelIdx) + seggerRttRdOffOffset
 
-		wrBuffer := Buffer{}
-		wrBuffer.WriteUint32LE(RdOff)
+		rdOffBuffer := NewBuffer(4)
+		rdOffBuffer.WriteUint32(RdOff, h.config.targetEndian)
 
-		err := h.WriteMem(addressRdOff, Memory32BitBlock, 1, wrBuffer.Bytes())
+		err := h.WriteMemContext(parent, addressRdOff, Memory32BitBlock, 1, rdOffBuffer.Bytes())
 
 		if err != nil {
 			return -1, err
 		}
+
+		rttBuffer.rdOff = RdOff
 	}
 
 	return data.Len(), nil
 }
 
-func parseRttControlBlock(ramBuffer []byte, controlBlock *seggerRttControlBlock) {
+func (h *StLink) rttChannelDescriptor(channelIdx uint32) uint32 {
+	return h.seggerRtt.ramStart + h.seggerRtt.offset + seggerRttControlBlockSize + channelIdx*seggerRttBufferSize
+}
+
+// RttExchange writes input to down channel and returns the data available on
+// up channel with the same number, both within a single update of the channels
+func (h *StLink) RttExchange(channel int, input []byte) ([]byte, error) {
+	if h.seggerRtt.controlBlock.channels == nil {
+		return nil, ErrRttNotInitialized
+	}
+
+	if channel < 0 || uint32(channel) >= h.seggerRtt.controlBlock.maxNumUpBuffers ||
+		uint32(channel) >= h.seggerRtt.controlBlock.maxNumDownBuffers {
+		return nil, fmt.Errorf("rtt channel %d does not exist in both directions", channel)
+	}
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		return nil, err
+	}
+
+	var inputErr error
+
+	if len(input) > 0 {
+		downIdx := h.seggerRtt.controlBlock.maxNumUpBuffers + uint32(channel)
+		written, err := h.writeRttDownChannel(downIdx, input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if written < len(input) {
+			inputErr = fmt.Errorf("rtt down channel full, wrote %d of %d bytes", written, len(input))
+		}
+	}
+
+	upChannel := h.seggerRtt.controlBlock.channels[channel]
+	output := bytes.NewBuffer([]byte{})
+
+	if upChannel.sizeOfBuffer > 0 && upChannel.rdOff != upChannel.wrOff {
+		channelBuffer, err := h.ReadBytes(upChannel.buffer, upChannel.sizeOfBuffer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = h.readDataFromRttChannelBuffer(context.Background(), uint32(channel), channelBuffer, output); err != nil {
+			return nil, err
+		}
+	}
+
+	return output.Bytes(), inputErr
+}
+
+// writes as much of data as fits into the down channel, returns the number of
+// written bytes
+func (h *StLink) writeRttDownChannel(channelIdx uint32, data []byte) (int, error) {
+	channel := h.seggerRtt.controlBlock.channels[channelIdx]
+
+	if channel == nil || channel.sizeOfBuffer == 0 {
+		return 0, errors.New("rtt down channel not configured on target")
+	}
+
+	if channel.wrOff >= channel.sizeOfBuffer || channel.rdOff >= channel.sizeOfBuffer {
+		return 0, errors.New("rtt channel offsets exceed channel buffer")
+	}
+
+	// one byte stays free to distinguish a full from an empty buffer
+	var free uint32
+
+	if channel.rdOff > channel.wrOff {
+		free = channel.rdOff - channel.wrOff - 1
+	} else {
+		free = channel.sizeOfBuffer - channel.wrOff + channel.rdOff - 1
+	}
+
+	count := uint32(len(data))
+
+	if count > free {
+		count = free
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	first := channel.sizeOfBuffer - channel.wrOff
+
+	if first > count {
+		first = count
+	}
+
+	if err := h.WriteBytes(channel.buffer+channel.wrOff, data[:first]); err != nil {
+		return 0, err
+	}
+
+	if count > first {
+		if err := h.WriteBytes(channel.buffer, data[first:count]); err != nil {
+			return 0, err
+		}
+	}
+
+	wrOff := (channel.wrOff + count) % channel.sizeOfBuffer
+
+	if err := h.WriteMemU32Slice(h.rttChannelDescriptor(channelIdx)+seggerRttWrOffOffset, []uint32{wrOff}); err != nil {
+		return 0, err
+	}
+
+	channel.wrOff = wrOff
+
+	return int(count), nil
+}
+
+func parseRttControlBlock(ramBuffer []byte, controlBlock *seggerRttControlBlock, e Endian) error {
+	if len(ramBuffer) < seggerRttControlBlockSize {
+		return errors.New("rtt control block truncated at end of search range")
+	}
+
 	copy(controlBlock.acId[:], ramBuffer) // is 16 bytes long
-	controlBlock.maxNumUpBuffers = convertToUint32(ramBuffer[len(controlBlock.acId):], littleEndian)
-	controlBlock.maxNumDownBuffers = convertToUint32(ramBuffer[len(controlBlock.acId)+4:], littleEndian)
+	controlBlock.maxNumUpBuffers = convertToUint32(ramBuffer[len(controlBlock.acId):], e)
+	controlBlock.maxNumDownBuffers = convertToUint32(ramBuffer[len(controlBlock.acId)+4:], e)
+
+	return nil
+}
+
+func parseRttChannel(ramBuffer []byte, e Endian) (*seggerRttChannel, error) {
+	if len(ramBuffer) < seggerRttBufferSize {
+		return nil, errors.New("rtt channel descriptor truncated")
+	}
+
+	return &seggerRttChannel{
+		name:         convertToUint32(ramBuffer[0:], e),
+		buffer:       convertToUint32(ramBuffer[4:], e),
+		sizeOfBuffer: convertToUint32(ramBuffer[8:], e),
+		wrOff:        convertToUint32(ramBuffer[12:], e),
+		rdOff:        convertToUint32(ramBuffer[16:], e),
+		flags:        convertToUint32(ramBuffer[20:], e),
+	}, nil
 }
@@ -0,0 +1,453 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+const (
+	fakeRamStart     = 0x20000000
+	fakeControlBlock = fakeRamStart + 0x100
+	fakeRttBuffers   = fakeRamStart + 0x400
+	fakeRttNames     = fakeRamStart + 0x800
+)
+
+// fakeRttTarget plants a segger rtt control block with its channel buffers in
+// the ram of a fake st-link
+type fakeRttTarget struct {
+	*fakeStLink
+
+	sizes []uint32 // buffer sizes, up channels first
+}
+
+func newFakeRttTarget(upSizes []uint32, downSizes []uint32) *fakeRttTarget {
+	t := &fakeRttTarget{fakeStLink: newFakeStLink(fakeRamStart, 0x1000)}
+	t.sizes = append(append([]uint32{}, upSizes...), downSizes...)
+
+	acId := make([]byte, 16)
+	copy(acId, "SEGGER RTT")
+
+	t.poke(fakeControlBlock, acId)
+	t.pokeUint32(fakeControlBlock+16, uint32(len(upSizes)))
+	t.pokeUint32(fakeControlBlock+20, uint32(len(downSizes)))
+
+	t.poke(fakeRttNames, []byte("Terminal\x00"))
+
+	buffer := uint32(fakeRttBuffers)
+
+	for i, size := range t.sizes {
+		desc := t.descriptor(i)
+
+		t.pokeUint32(desc, fakeRttNames)
+		t.pokeUint32(desc+4, buffer)
+		t.pokeUint32(desc+8, size)
+
+		buffer += size
+	}
+
+	return t
+}
+
+func (t *fakeRttTarget) descriptor(channel int) uint32 {
+	return fakeControlBlock + seggerRttControlBlockSize + uint32(channel)*seggerRttBufferSize
+}
+
+func (t *fakeRttTarget) offsets(channel int) (uint32, uint32) {
+	desc := t.descriptor(channel)
+	return t.peekUint32(desc + seggerRttWrOffOffset), t.peekUint32(desc + seggerRttRdOffOffset)
+}
+
+func (t *fakeRttTarget) setOffsets(channel int, wrOff uint32, rdOff uint32) {
+	desc := t.descriptor(channel)
+
+	t.pokeUint32(desc+seggerRttWrOffOffset, wrOff)
+	t.pokeUint32(desc+seggerRttRdOffOffset, rdOff)
+}
+
+// writes data into the ring buffer of an up channel like the target firmware
+func (t *fakeRttTarget) push(channel int, data []byte) {
+	desc := t.descriptor(channel)
+	buffer := t.peekUint32(desc + 4)
+	wrOff, _ := t.offsets(channel)
+
+	for _, b := range data {
+		t.poke(buffer+wrOff, []byte{b})
+		wrOff = (wrOff + 1) % t.sizes[channel]
+	}
+
+	t.pokeUint32(desc+seggerRttWrOffOffset, wrOff)
+}
+
+// returns a handle with initialized rtt and updated channels
+func (t *fakeRttTarget) handle(tb testing.TB) *StLink {
+	h := newFakeHandle(t.fakeStLink)
+
+	if err := h.InitializeRtt([][2]uint64{{fakeRamStart, 0x400}}); err != nil {
+		tb.Fatalf("InitializeRtt: %v", err)
+	}
+
+	if err := h.UpdateRttChannels(true); err != nil {
+		tb.Fatalf("UpdateRttChannels: %v", err)
+	}
+
+	return h
+}
+
+func readAll(tb testing.TB, h *StLink) map[int][]byte {
+	received := make(map[int][]byte)
+
+	err := h.ReadRttChannels(func(channel int, data []byte) error {
+		received[channel] = append(received[channel], data...)
+		return nil
+	})
+
+	if err != nil {
+		tb.Fatalf("ReadRttChannels: %v", err)
+	}
+
+	return received
+}
+
+func TestParseRttControlBlock(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16, 32}, []uint32{8})
+
+	var controlBlock seggerRttControlBlock
+
+	if err := parseRttControlBlock(target.peek(fakeControlBlock, seggerRttControlBlockSize), &controlBlock, littleEndian); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(controlBlock.acId[:], []byte("SEGGER RTT")) {
+		t.Errorf("acId = %q", controlBlock.acId)
+	}
+
+	if controlBlock.maxNumUpBuffers != 2 || controlBlock.maxNumDownBuffers != 1 {
+		t.Errorf("buffers = %d up, %d down, want 2 up, 1 down", controlBlock.maxNumUpBuffers, controlBlock.maxNumDownBuffers)
+	}
+
+	if err := parseRttControlBlock(make([]byte, seggerRttControlBlockSize-1), &controlBlock, littleEndian); err == nil {
+		t.Error("truncated control block parsed without error")
+	}
+}
+
+func TestParseRttChannel(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16, 32}, []uint32{8})
+	target.setOffsets(1, 7, 3)
+
+	channel, err := parseRttChannel(target.peek(target.descriptor(1), seggerRttBufferSize), littleEndian)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := seggerRttChannel{name: fakeRttNames, buffer: fakeRttBuffers + 16, sizeOfBuffer: 32, wrOff: 7, rdOff: 3}
+
+	if *channel != want {
+		t.Errorf("channel = %+v, want %+v", *channel, want)
+	}
+
+	if _, err = parseRttChannel(make([]byte, seggerRttBufferSize-1), littleEndian); err == nil {
+		t.Error("truncated channel descriptor parsed without error")
+	}
+}
+
+func TestInitializeRtt(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16, 32}, []uint32{8})
+	h := target.handle(t)
+
+	if h.seggerRtt.ramStart+h.seggerRtt.offset != fakeControlBlock {
+		t.Errorf("control block found at 0x%08x, want 0x%08x", h.seggerRtt.ramStart+h.seggerRtt.offset, fakeControlBlock)
+	}
+
+	if len(h.seggerRtt.controlBlock.channels) != 3 {
+		t.Fatalf("%d channels, want 3", len(h.seggerRtt.controlBlock.channels))
+	}
+
+	if name := h.seggerRtt.controlBlock.channels[0].channelName; name != "Terminal" {
+		t.Errorf("channel name = %q, want Terminal", name)
+	}
+}
+
+func TestReadRttChannels(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16, 32}, []uint32{8})
+	h := target.handle(t)
+
+	target.push(0, []byte("hello"))
+	target.push(1, []byte("world"))
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	received := readAll(t, h)
+
+	if string(received[0]) != "hello" || string(received[1]) != "world" {
+		t.Errorf("received %q", received)
+	}
+
+	for channel := 0; channel < 2; channel++ {
+		if wrOff, rdOff := target.offsets(channel); rdOff != wrOff {
+			t.Errorf("channel %d: rdOff %d not written back (wrOff %d)", channel, rdOff, wrOff)
+		}
+	}
+
+	// nothing new, nothing delivered
+	if received = readAll(t, h); len(received) != 0 {
+		t.Errorf("received %q after draining", received)
+	}
+}
+
+func TestReadRttChannelsWrapAround(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16}, []uint32{8})
+	target.setOffsets(0, 13, 13)
+
+	h := target.handle(t)
+
+	target.push(0, []byte("wrapping"))
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if info := h.rttChannelInfo(0, h.seggerRtt.controlBlock.channels[0]); info.Pending != 8 {
+		t.Errorf("pending = %d, want 8", info.Pending)
+	}
+
+	received := readAll(t, h)
+
+	if string(received[0]) != "wrapping" {
+		t.Errorf("received %q, want \"wrapping\"", received[0])
+	}
+
+	if wrOff, rdOff := target.offsets(0); wrOff != 5 || rdOff != 5 {
+		t.Errorf("offsets wrOff %d rdOff %d, want 5 and 5", wrOff, rdOff)
+	}
+}
+
+func TestDrainRttChannelsInclude(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16, 32}, []uint32{8})
+	h := target.handle(t)
+
+	target.push(0, []byte("skipped"))
+	target.push(1, []byte("routed"))
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(map[int][]byte)
+
+	drained, err := h.drainRttChannels(context.Background(), func(channel int, data []byte) error {
+		received[channel] = data
+		return nil
+	}, func(channel int) bool {
+		return channel == 1
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if drained != 1 || string(received[1]) != "routed" || received[0] != nil {
+		t.Errorf("drained %d channels, received %q", drained, received)
+	}
+
+	if _, rdOff := target.offsets(0); rdOff != 0 {
+		t.Errorf("excluded channel consumed, rdOff %d", rdOff)
+	}
+}
+
+func TestRttPeekMode(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16}, []uint32{8})
+	h := target.handle(t)
+	h.SetRttPeekMode(true)
+
+	target.push(0, []byte("peek"))
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if received := readAll(t, h); string(received[0]) != "peek" {
+			t.Errorf("read %d: received %q, want \"peek\"", i, received[0])
+		}
+	}
+
+	if _, rdOff := target.offsets(0); rdOff != 0 {
+		t.Errorf("peek mode wrote rdOff %d", rdOff)
+	}
+}
+
+func TestRttWriterFailedWrite(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16}, []uint32{8})
+	h := target.handle(t)
+
+	w, err := h.NewRttWriter(0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// memory writes fail with an ap fault
+	failWrite := false
+
+	target.handler = func(cmd []byte) ([]byte, bool) {
+		switch {
+		case cmd[0] == cmdDebug && cmd[1] == debugWriteMem8Bit:
+			failWrite = true
+		case cmd[0] == cmdDebug && cmd[1] == debugApiV2GetLastRWStatus2 && failWrite:
+			failWrite = false
+			return append([]byte{swdAccessPortFault}, make([]byte, 11)...), true
+		}
+
+		return nil, false
+	}
+
+	if n, err := w.Write([]byte("abc")); n != 0 || err == nil {
+		t.Errorf("failed write returned %d, %v", n, err)
+	}
+
+	if w.Buffered() != 0 {
+		t.Errorf("%d bytes queued after failed write", w.Buffered())
+	}
+
+	target.handler = nil
+
+	if n, err := w.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("write returned %d, %v", n, err)
+	}
+
+	if data := target.peek(fakeRttBuffers+16, 3); string(data) != "abc" {
+		t.Errorf("down channel holds %q, want \"abc\"", data)
+	}
+
+	if wrOff, _ := target.offsets(1); wrOff != 3 {
+		t.Errorf("wrOff = %d, want 3", wrOff)
+	}
+}
+
+func TestDrainRttChannelsCallbackError(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16, 32}, []uint32{8})
+	h := target.handle(t)
+
+	target.push(0, []byte("first"))
+	target.push(1, []byte("second"))
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	drained, err := h.drainRttChannels(context.Background(), func(channel int, data []byte) error {
+		calls++
+		cancel()
+
+		return ctx.Err()
+	}, nil)
+
+	if err != context.Canceled || drained != 1 || calls != 1 {
+		t.Errorf("drained %d channels with %d calls, err %v", drained, calls, err)
+	}
+
+	// the second channel is left on the target
+	if wrOff, rdOff := target.offsets(1); rdOff == wrOff {
+		t.Error("channel drained after callback error")
+	}
+}
+
+func TestDrainRttChannelsOffsetError(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16}, []uint32{8})
+	h := target.handle(t)
+
+	target.setOffsets(0, 20, 0)
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	drained, err := h.drainRttChannels(context.Background(), func(channel int, data []byte) error {
+		t.Errorf("callback called with %q", data)
+		return nil
+	}, nil)
+
+	if err == nil || drained != 0 {
+		t.Errorf("drained %d channels, err %v", drained, err)
+	}
+}
+
+func TestChannelDirection(t *testing.T) {
+	layouts := []struct{ up, down int }{{1, 3}, {3, 1}, {2, 2}}
+
+	for _, layout := range layouts {
+		target := newFakeRttTarget(channelSizes(layout.up), channelSizes(layout.down))
+		h := target.handle(t)
+
+		for i := 0; i < layout.up+layout.down; i++ {
+			want := RttDirectionUp
+
+			if i >= layout.up {
+				want = RttDirectionDown
+			}
+
+			if direction := h.channelDirection(i); direction != want {
+				t.Errorf("%d up, %d down: channel %d has direction %v, want %v", layout.up, layout.down, i, direction, want)
+			}
+		}
+
+		// pending data of the down channels is left for the target
+		target.push(layout.up, []byte("down"))
+		target.push(0, []byte("up"))
+
+		if err := h.UpdateRttChannels(false); err != nil {
+			t.Fatal(err)
+		}
+
+		received := readAll(t, h)
+
+		if len(received) != 1 || string(received[0]) != "up" {
+			t.Errorf("%d up, %d down: received %q", layout.up, layout.down, received)
+		}
+	}
+}
+
+// returns n channel buffer sizes of 16 bytes
+func channelSizes(n int) []uint32 {
+	sizes := make([]uint32, n)
+
+	for i := range sizes {
+		sizes[i] = 16
+	}
+
+	return sizes
+}
+
+func TestRttFillPercentBeforeUpdate(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16}, []uint32{8})
+	h := newFakeHandle(target.fakeStLink)
+
+	if err := h.InitializeRtt([][2]uint64{{fakeRamStart, 0x400}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.RttFillPercent(0); err == nil {
+		t.Error("fill level of a channel not read yet returned no error")
+	}
+
+	if err := h.UpdateRttChannels(false); err != nil {
+		t.Fatal(err)
+	}
+
+	target.push(0, []byte("abcd"))
+
+	if fill, err := h.RttFillPercent(0); err != nil || fill != 25 {
+		t.Errorf("fill level %v, %v, want 25", fill, err)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+// Package rttfmt formats rtt output for capture files
+package rttfmt
+
+import (
+	"encoding/hex"
+	"io"
+)
+
+// HexDumpWriter returns a writer formatting all written bytes as a canonical
+// hex dump (offset, 16 hex bytes and their ascii representation per line) to w.
+// Incomplete lines are kept until more data arrives, Close writes them.
+func HexDumpWriter(w io.Writer) io.WriteCloser {
+	return hex.Dumper(w)
+}
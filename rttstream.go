@@ -0,0 +1,120 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"context"
+	"time"
+)
+
+// default poll interval limits of StartRttStream
+const (
+	rttStreamMinPollInterval = 10 * time.Millisecond
+	rttStreamMaxPollInterval = 500 * time.Millisecond
+)
+
+// data read from an rtt up channel by StartRttStream
+type RttPacket struct {
+	Channel int
+	Data    []byte
+
+	PollInterval time.Duration // poll interval of the stream when the data was read
+}
+
+// StartRttStream polls the rtt up channels in a goroutine until ctx is
+// cancelled. Data is delivered on the packet channel, transport errors on the
+// error channel, polling continues after an error. The error channel holds the
+// oldest unread error, further errors are dropped until it was read, so the
+// stream does not stall if only packets are consumed. Both channels are closed
+// when the stream ends. InitializeRtt has to be called before.
+//
+// The poll interval adapts between minInterval and maxInterval: it is halved
+// after reading data and doubled while all channels are empty. Zero values
+// select the defaults of 10ms and 500ms.
+func (h *StLink) StartRttStream(ctx context.Context, minInterval, maxInterval time.Duration) (<-chan RttPacket, <-chan error) {
+	if minInterval <= 0 {
+		minInterval = rttStreamMinPollInterval
+	}
+
+	if maxInterval <= 0 {
+		maxInterval = rttStreamMaxPollInterval
+	}
+
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	packets := make(chan RttPacket)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(packets)
+		defer close(errs)
+
+		interval := minInterval
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return
+			}
+
+			received, err := h.pollRttStream(ctx, packets, interval)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				select {
+				case errs <- err:
+				default:
+					logger.Debugf("dropping rtt stream error, previous one not read yet: %v", err)
+				}
+			}
+
+			if received > 0 {
+				interval /= 2
+			} else {
+				interval *= 2
+			}
+
+			if interval < minInterval {
+				interval = minInterval
+			} else if interval > maxInterval {
+				interval = maxInterval
+			}
+
+			timer.Reset(interval)
+		}
+	}()
+
+	return packets, errs
+}
+
+// reads all up channels once, returns the number of delivered packets
+func (h *StLink) pollRttStream(ctx context.Context, packets chan<- RttPacket, interval time.Duration) (int, error) {
+	if err := h.updateRttChannels(ctx, false); err != nil {
+		return 0, err
+	}
+
+	received := 0
+
+	err := h.readRttChannels(ctx, func(channel int, data []byte) error {
+		select {
+		case packets <- RttPacket{Channel: channel, Data: data, PollInterval: interval}:
+			received++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil)
+
+	return received, err
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// a consumer reading packets only must not stall the stream on repeated errors
+func TestRttStreamUnreadErrors(t *testing.T) {
+	target := newFakeRttTarget([]uint32{16}, []uint32{8})
+	h := target.handle(t)
+
+	// the first polls fail with an ap fault
+	failures := 5
+
+	target.handler = func(cmd []byte) ([]byte, bool) {
+		if cmd[0] == cmdDebug && cmd[1] == debugApiV2GetLastRWStatus2 && failures > 0 {
+			failures--
+			return append([]byte{swdAccessPortFault}, make([]byte, 11)...), true
+		}
+
+		return nil, false
+	}
+
+	target.push(0, []byte("stream"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	packets, errs := h.StartRttStream(ctx, time.Millisecond, time.Millisecond)
+
+	select {
+	case packet := <-packets:
+		if packet.Channel != 0 || string(packet.Data) != "stream" {
+			t.Errorf("received %+v", packet)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet received, stream stalled on errors")
+	}
+
+	cancel()
+
+	// the first error is kept for the consumer
+	if err, ok := <-errs; !ok || err == nil {
+		t.Errorf("error channel returned %v, %v", err, ok)
+	}
+
+	for range packets {
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"fmt"
+	"time"
+)
+
+const rttWriterPollInterval = 10 * time.Millisecond
+
+// RttWriter is an io.Writer for an rtt down channel. Data not fitting into the
+// channel buffer of the target is kept host-side until Flush or the next Write.
+type RttWriter struct {
+	h       *StLink
+	channel int
+
+	pending []byte
+	timeout time.Duration
+}
+
+// NewRttWriter returns a writer for down channel, InitializeRtt has to be
+// called before
+func (h *StLink) NewRttWriter(channel int) (*RttWriter, error) {
+	if h.seggerRtt.controlBlock.channels == nil {
+		return nil, ErrRttNotInitialized
+	}
+
+	if channel < 0 || uint32(channel) >= h.seggerRtt.controlBlock.maxNumDownBuffers {
+		return nil, fmt.Errorf("rtt down channel %d does not exist", channel)
+	}
+
+	return &RttWriter{h: h, channel: channel, timeout: usbWriteTimeout}, nil
+}
+
+// SetTimeout sets how long Flush waits for the target to make room in the
+// channel buffer, default is the usb write timeout
+func (w *RttWriter) SetTimeout(timeout time.Duration) {
+	w.timeout = timeout
+}
+
+// Buffered returns the number of bytes not yet written to the target
+func (w *RttWriter) Buffered() int {
+	return len(w.pending)
+}
+
+// Write queues p and writes as much of the queued data as the channel buffer
+// currently takes, it does not wait for the target. If the target access
+// fails p is not queued.
+func (w *RttWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+
+	if _, err := w.writePending(); err != nil {
+		w.pending = w.pending[:len(w.pending)-len(p)]
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush blocks until all queued data was written to the channel buffer of the
+// target or the timeout of the writer passed
+func (w *RttWriter) Flush() error {
+	deadline := time.Now().Add(w.timeout)
+
+	for len(w.pending) > 0 {
+		written, err := w.writePending()
+
+		if err != nil {
+			return err
+		}
+
+		if written > 0 || len(w.pending) == 0 {
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("rtt down channel %d did not take %d pending bytes within %v",
+				w.channel, len(w.pending), w.timeout)
+		}
+
+		time.Sleep(rttWriterPollInterval)
+	}
+
+	return nil
+}
+
+func (w *RttWriter) writePending() (int, error) {
+	if len(w.pending) == 0 {
+		return 0, nil
+	}
+
+	if err := w.h.UpdateRttChannels(false); err != nil {
+		return 0, err
+	}
+
+	downIdx := w.h.seggerRtt.controlBlock.maxNumUpBuffers + uint32(w.channel)
+	written, err := w.h.writeRttDownChannel(downIdx, w.pending)
+
+	if err != nil {
+		return 0, err
+	}
+
+	w.pending = w.pending[written:]
+
+	return written, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// st-link V1 is a mass storage device, commands are wrapped into scsi command
+// block wrappers (CBW) and every command is answered by a status wrapper (CSW)
+const (
+	scsiCbwSignature = 0x43425355 // "USBC"
+	scsiCswSignature = 0x53425355 // "USBS"
+
+	scsiCbwSize = 31
+	scsiCswSize = 13
+
+	scsiCswStatusPassed = 0
+	scsiCswStatusFailed = 1 // details are fetched with a request sense command
+)
+
+// wraps the command into a command block wrapper, dataLength is the size of
+// the data stage following the command
+func (h *StLink) scsiCommandBlock(cmd []byte, direction usbTransferDirection, dataLength uint32) []byte {
+	cbw := NewBuffer(scsiCbwSize)
+
+	h.scsiTag++
+
+	cbw.WriteUint32LE(scsiCbwSignature)
+	cbw.WriteUint32LE(h.scsiTag)
+	cbw.WriteUint32LE(dataLength)
+
+	if direction == transferIncoming {
+		cbw.WriteByte(usbEndpointIn)
+	} else {
+		cbw.WriteByte(usbEndpointOut)
+	}
+
+	cbw.WriteByte(0) // lun
+	cbw.WriteByte(cmdSizeV1)
+
+	if len(cmd) > cmdSizeV2 {
+		cmd = cmd[:cmdSizeV2]
+	}
+
+	cbw.Write(cmd)
+
+	for cbw.Len() < scsiCbwSize {
+		cbw.WriteByte(0)
+	}
+
+	return cbw.Bytes()
+}
+
+// reads the status wrapper of the last command and requests the sense data if
+// the command failed, has to be called with usbMutex held
+func (h *StLink) usbScsiStatus(parent context.Context) error {
+	status, err := h.usbReadScsiCsw(parent)
+
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case scsiCswStatusPassed:
+		return nil
+
+	case scsiCswStatusFailed:
+		return h.usbScsiRequestSense(parent)
+
+	default:
+		return fmt.Errorf("st-link V1 command failed with scsi status %d", status)
+	}
+}
+
+func (h *StLink) usbReadScsiCsw(parent context.Context) (byte, error) {
+	csw := make([]byte, scsiCswSize)
+
+	bytesRead, err := h.usbRead(parent, csw)
+
+	if err != nil {
+		return 0, h.checkDisconnected(err)
+	}
+
+	if bytesRead < scsiCswSize || convertToUint32(csw, littleEndian) != scsiCswSignature {
+		return 0, errors.New("invalid scsi status wrapper from st-link V1")
+	}
+
+	if tag := convertToUint32(csw[4:], littleEndian); tag != h.scsiTag {
+		return 0, fmt.Errorf("scsi status tag %d does not match command tag %d", tag, h.scsiTag)
+	}
+
+	return csw[12], nil
+}
+
+func (h *StLink) usbScsiRequestSense(parent context.Context) error {
+	cmd := []byte{cmdRequestSense, 0, 0, 0, requestSenseLength}
+
+	_, err := h.usbWrite(parent, h.scsiCommandBlock(cmd, transferIncoming, requestSenseLength))
+
+	if err != nil {
+		return h.checkDisconnected(err)
+	}
+
+	sense := make([]byte, requestSenseLength)
+
+	bytesRead, err := h.usbRead(parent, sense)
+
+	if err != nil {
+		return h.checkDisconnected(err)
+	}
+
+	if _, err = h.usbReadScsiCsw(parent); err != nil {
+		return err
+	}
+
+	if bytesRead < requestSenseLength {
+		return errors.New("st-link V1 command failed, truncated sense data")
+	}
+
+	return fmt.Errorf("st-link V1 command failed (sense key 0x%x, asc 0x%02x, ascq 0x%02x)",
+		sense[2]&0x0f, sense[12], sense[13])
+}
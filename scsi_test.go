@@ -0,0 +1,137 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// st-link V1 transport answering reads from a script, writes are recorded
+type scsiTransport struct {
+	writes [][]byte
+	reads  [][]byte
+}
+
+func (s *scsiTransport) write(parent context.Context, buffer []byte) (int, error) {
+	s.writes = append(s.writes, append([]byte{}, buffer...))
+	return len(buffer), nil
+}
+
+func (s *scsiTransport) read(parent context.Context, buffer []byte) (int, error) {
+	if len(s.reads) == 0 {
+		return 0, context.DeadlineExceeded
+	}
+
+	n := copy(buffer, s.reads[0])
+	s.reads = s.reads[1:]
+
+	return n, nil
+}
+
+func (s *scsiTransport) readTrace(parent context.Context, buffer []byte, timeout time.Duration) (int, error) {
+	return 0, context.DeadlineExceeded
+}
+
+func newScsiHandle(s *scsiTransport, tag uint32) *StLink {
+	h := &StLink{transport: s, scsiTag: tag}
+	h.version.stlink = 1
+
+	return h
+}
+
+func scsiStatusWrapper(tag uint32, status byte) []byte {
+	csw := NewBuffer(scsiCswSize)
+
+	csw.WriteUint32LE(scsiCswSignature)
+	csw.WriteUint32LE(tag)
+	csw.WriteUint32LE(0) // residue
+	csw.WriteByte(status)
+
+	return csw.Bytes()
+}
+
+func TestScsiCommandBlockLayout(t *testing.T) {
+	h := newScsiHandle(&scsiTransport{}, 6)
+	cmd := []byte{cmdDebug, debugReadMem32Bit, 0x00, 0x10, 0x00, 0x20, 0x40, 0x00}
+
+	cbw := h.scsiCommandBlock(cmd, transferIncoming, 0x40)
+
+	want := []byte{
+		'U', 'S', 'B', 'C', // signature
+		7, 0, 0, 0, // tag
+		0x40, 0, 0, 0, // data transfer length
+		usbEndpointIn, // flags
+		0,             // lun
+		cmdSizeV1,     // command block length
+	}
+	want = append(want, cmd...)
+	want = append(want, make([]byte, scsiCbwSize-len(want))...)
+
+	if !bytes.Equal(cbw, want) {
+		t.Errorf("cbw = % x, want % x", cbw, want)
+	}
+
+	cbw = h.scsiCommandBlock(cmd, transferOutgoing, 0)
+
+	if len(cbw) != scsiCbwSize || cbw[4] != 8 || cbw[12] != usbEndpointOut {
+		t.Errorf("outgoing cbw = % x, want tag 8 and direction out", cbw)
+	}
+}
+
+func TestScsiStatusTagMismatch(t *testing.T) {
+	s := &scsiTransport{reads: [][]byte{scsiStatusWrapper(4, scsiCswStatusPassed)}}
+	h := newScsiHandle(s, 5)
+
+	err := h.usbScsiStatus(context.Background())
+
+	if err == nil || !strings.Contains(err.Error(), "tag") {
+		t.Fatalf("err = %v, want a tag mismatch", err)
+	}
+
+	if len(s.writes) != 0 {
+		t.Errorf("%d writes after a mismatched status, want none", len(s.writes))
+	}
+}
+
+func TestScsiStatusFailedRequestsSense(t *testing.T) {
+	sense := make([]byte, requestSenseLength)
+	sense[2] = 0x05  // illegal request
+	sense[12] = 0x24 // invalid field in cdb
+
+	s := &scsiTransport{reads: [][]byte{
+		scsiStatusWrapper(3, scsiCswStatusFailed),
+		sense,
+		scsiStatusWrapper(4, scsiCswStatusPassed),
+	}}
+	h := newScsiHandle(s, 3)
+
+	err := h.usbScsiStatus(context.Background())
+
+	if err == nil || !strings.Contains(err.Error(), "sense key 0x5, asc 0x24, ascq 0x00") {
+		t.Fatalf("err = %v, want the sense data", err)
+	}
+
+	if len(s.writes) != 1 {
+		t.Fatalf("%d writes, want the request sense command only", len(s.writes))
+	}
+
+	cbw := s.writes[0]
+
+	if len(cbw) != scsiCbwSize || cbw[4] != 4 || cbw[8] != requestSenseLength || cbw[12] != usbEndpointIn {
+		t.Errorf("request sense cbw = % x, want tag 4 reading %d bytes", cbw, requestSenseLength)
+	}
+
+	if cbw[15] != cmdRequestSense || cbw[19] != requestSenseLength {
+		t.Errorf("request sense command = % x", cbw[15:])
+	}
+
+	if len(s.reads) != 0 {
+		t.Errorf("%d reads left, want sense data and its status read", len(s.reads))
+	}
+}
@@ -46,7 +46,9 @@ func (h *StLink) setSpeedV3(isJtag bool, kHz uint32, querySpeed bool) (uint32, e
 
 	var smap = make([]speedMap, v3MaxFreqNb)
 
-	h.usbGetComFreq(isJtag, &smap)
+	if err := h.usbGetComFreq(isJtag, &smap); err != nil {
+		return kHz, err
+	}
 
 	speedIndex, err := matchSpeedMap(smap, kHz, querySpeed)
 
@@ -125,14 +127,38 @@ func (h *StLink) usbGetComFreq(isJtag bool, smap *[]speedMap) error {
 
 	err := h.usbTransferErrCheck(ctx, 52)
 
+	if err != nil {
+		return err
+	}
+
+	// the response is zero padded to the requested length, check the length
+	// actually received (some clones answer with less)
+	if ctx.received < 12 {
+		return fmt.Errorf("truncated com frequency response of %d bytes", ctx.received)
+	}
+
 	size := uint32(ctx.DataBytes()[8])
 
+	if size == 0 {
+		return errors.New("st-link reports no com frequencies")
+	}
+
 	if size > v3MaxFreqNb {
 		size = v3MaxFreqNb
 	}
 
+	if ctx.received < 12+size*4 {
+		return fmt.Errorf("com frequency response of %d bytes too short for %d frequencies", ctx.received, size)
+	}
+
+	speeds, convErr := ctx.dataBuf.ReadUint32Slice(12, int(size), littleEndian)
+
+	if convErr != nil {
+		return convErr
+	}
+
 	for i := uint32(0); i < size; i++ {
-		(*smap)[i].speed = convertToUint32(ctx.DataBytes()[12+4*i:], littleEndian)
+		(*smap)[i].speed = speeds[i]
 		(*smap)[i].speedDivisor = i
 	}
 
@@ -141,7 +167,7 @@ func (h *StLink) usbGetComFreq(isJtag bool, smap *[]speedMap) error {
 		(*smap)[i].speed = 0
 	}
 
-	return err
+	return nil
 }
 
 func (h *StLink) usbSetComFreq(isJtag bool, frequency uint32) error {
@@ -224,3 +250,41 @@ func dumpSpeedMap(smap []speedMap) {
 		}
 	}
 }
+
+// SetSwdConfig would set the idle cycles after swd transfers. The st-link
+// firmware (up to V2J39/V3J9) has no command for the swd line configuration,
+// the st-link handles turnaround and idle cycles itself, so ErrUnsupported is
+// returned. Lower the speed with SetSpeed for marginal connections instead.
+func (h *StLink) SetSwdConfig(idleCycles uint8) error {
+	logger.Debugf("swd configuration with %d idle cycles requested but not supported", idleCycles)
+
+	return ErrUnsupported
+}
+
+// CurrentSpeed returns the interface speed in kHz last set with SetSpeed, the
+// configured initial speed before
+func (h *StLink) CurrentSpeed() uint32 {
+	return h.config.initialSpeed
+}
+
+// WithSpeed runs fn with the interface speed set to khz and restores the
+// previous speed afterwards, also if fn fails
+func (h *StLink) WithSpeed(khz uint32, fn func() error) error {
+	previous := h.CurrentSpeed()
+
+	if _, err := h.SetSpeed(khz, false); err != nil {
+		return err
+	}
+
+	err := fn()
+
+	if _, restoreErr := h.SetSpeed(previous, false); restoreErr != nil {
+		logger.Warnf("could not restore interface speed of %d kHz: %s", previous, restoreErr)
+
+		if err == nil {
+			err = restoreErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import "testing"
+
+// builds a com frequency response with the given frequencies (in kHz)
+func comFreqResponse(frequencies ...uint32) []byte {
+	buf := NewBuffer(52)
+
+	buf.WriteUint32LE(uint32(debugErrorOk))
+	buf.WriteUint32LE(0)
+	buf.WriteUint32LE(uint32(len(frequencies)))
+
+	for _, f := range frequencies {
+		buf.WriteUint32LE(f)
+	}
+
+	return buf.Bytes()
+}
+
+func newComFreqHandle(response []byte) *StLink {
+	f := newFakeStLink(fakeRamStart, 0x100)
+
+	f.handler = func(cmd []byte) ([]byte, bool) {
+		if cmd[0] == cmdDebug && cmd[1] == debugApiV3GetComFreq {
+			return response, true
+		}
+
+		return nil, false
+	}
+
+	h := newFakeHandle(f)
+	h.version.stlink = 3
+	h.version.jtagApi = jTagApiV3
+
+	return h
+}
+
+func TestUsbGetComFreq(t *testing.T) {
+	h := newComFreqHandle(comFreqResponse(24000, 8000, 3300))
+	smap := make([]speedMap, v3MaxFreqNb)
+
+	for i := range smap {
+		smap[i].speed = 1
+	}
+
+	if err := h.usbGetComFreq(false, &smap); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint32{24000, 8000, 3300, 0, 0, 0, 0, 0, 0, 0}
+
+	for i := range want {
+		if smap[i].speed != want[i] {
+			t.Errorf("frequency %d = %d, want %d", i, smap[i].speed, want[i])
+		}
+	}
+}
+
+func TestUsbGetComFreqTruncated(t *testing.T) {
+	full := comFreqResponse(24000, 8000, 3300)
+
+	// header cut off, frequency table cut off and an empty table
+	responses := [][]byte{full[:11], full[:12+2*4], full[:12+3*4-1], comFreqResponse()}
+
+	for _, response := range responses {
+		h := newComFreqHandle(response)
+		smap := make([]speedMap, v3MaxFreqNb)
+
+		if err := h.usbGetComFreq(false, &smap); err == nil {
+			t.Errorf("response of %d bytes accepted", len(response))
+		}
+	}
+}
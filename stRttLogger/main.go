@@ -173,8 +173,7 @@ func main() {
 	if err != nil {
 		logger.Error("error during initialization of RTT: ", err)
 
-		stLink.Close()
-		gostlink.CloseUSB()
+		stLink.Shutdown()
 
 		os.Exit(-1)
 	} else {
@@ -205,8 +204,7 @@ func main() {
 			time.Sleep(50 * 1000 * 1000)
 		}
 
-		stLink.Close()
-		gostlink.CloseUSB()
+		stLink.Shutdown()
 
 		os.Exit(0)
 	}
@@ -0,0 +1,43 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// StLinkStats counts the usb traffic of a handle since it was opened or the
+// last call of ResetStats
+type StLinkStats struct {
+	Transfers    uint64 // commands sent to the st-link
+	BytesRead    uint64 // bytes received in data stages
+	BytesWritten uint64 // bytes sent in data stages
+	Retries      uint64 // commands repeated after a wait or transfer error
+	Errors       uint64 // failed usb transfers and error status responses
+}
+
+// Stats returns a snapshot of the transfer counters of the handle
+func (h *StLink) Stats() StLinkStats {
+	h.statsMutex.Lock()
+	defer h.statsMutex.Unlock()
+
+	return h.stats
+}
+
+// ResetStats sets all transfer counters of the handle to zero
+func (h *StLink) ResetStats() {
+	h.statsMutex.Lock()
+	defer h.statsMutex.Unlock()
+
+	h.stats = StLinkStats{}
+}
+
+// updates the counters, called with zero for the ones not affected
+func (h *StLink) countStats(transfers, bytesRead, bytesWritten, retries, errors uint64) {
+	h.statsMutex.Lock()
+	defer h.statsMutex.Unlock()
+
+	h.stats.Transfers += transfers
+	h.stats.BytesRead += bytesRead
+	h.stats.BytesWritten += bytesWritten
+	h.stats.Retries += retries
+	h.stats.Errors += errors
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+// summary of the probe and target state, e.g. for status displays
+type TargetStatus struct {
+	Mode      StLinkMode // transport the handle was opened with
+	Connected bool       // st-link is in debug mode
+	Core      CoreState
+	Voltage   float32 // target voltage, -1 if the st-link cannot measure it
+	Powered   bool    // target voltage is sufficient for debugging
+}
+
+// TargetStatus queries mode, core state and target voltage. Only a failing
+// mode query is returned as error, the other values are reported as unknown.
+func (h *StLink) TargetStatus() (TargetStatus, error) {
+	status := TargetStatus{Mode: h.stMode, Core: CoreStateUnknown, Voltage: -1}
+
+	mode, err := h.usbCurrentMode()
+
+	if err != nil {
+		return status, err
+	}
+
+	status.Connected = mode == deviceModeDebug || mode == deviceModeSwim
+
+	if voltage, err := h.GetTargetVoltage(); err == nil {
+		status.Voltage = voltage
+		status.Powered = voltage >= minimumTargetVoltage
+	}
+
+	if status.Connected && h.stMode != StLinkModeDebugSwim {
+		if core, err := h.CoreStatus(); err == nil {
+			status.Core = core
+		}
+	}
+
+	return status, nil
+}
@@ -6,11 +6,16 @@ package gostlink
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/boljen/go-bitmap"
 	"github.com/google/gousb"
+	"github.com/sirupsen/logrus"
 )
 
 const AllSupportedVIds = 0xFFFF
@@ -27,11 +32,17 @@ type stLinkVersion struct {
 	jtagApi stLinkApiVersion
 
 	flags bitmap.Bitmap
+
+	str       string // version string like V2J37S7
+	versionEx []byte // raw response of GET_VERSION_EX, nil for V2 st-links
 }
 
 type stLinkTrace struct {
-	enabled  bool
-	sourceHz uint32
+	enabled   bool
+	sourceHz  uint32
+	clockInHz uint32 // trace clock input, drives the itm timestamp counter
+
+	endpointVerified bool // trace data was received on the trace endpoint
 }
 
 /** */
@@ -51,6 +62,9 @@ type StLink struct {
 
 	stMode StLinkMode
 
+	serialNo    string
+	productName string // usb product string, empty until read by ProductName
+
 	version stLinkVersion
 
 	trace stLinkTrace
@@ -60,6 +74,35 @@ type StLink struct {
 	reconnectPending bool // reconnect is needed next time we try to query the status
 
 	maxMemPacket uint32
+
+	openedAp bitmap.Bitmap // access ports initialized on this st-link
+
+	device *Stm32Device // detected target device, nil until detected
+
+	initialCoreState CoreState // core state read after connecting
+
+	cpuId uint32 // CPUID register read on connect, 0 if the read failed
+
+	retryPolicy RetryPolicy
+
+	verifyWrites      bool
+	strictAccessWidth bool
+	dryRun            bool // log commands instead of sending them
+
+	transport usbTransport // replaces the usb endpoints if set
+
+	usbMutex     sync.Mutex // serializes usb transfers of the handle
+	disconnected bool       // st-link was removed, all transfers fail
+	scsiTag      uint32     // tag of the last scsi command sent to a st-link V1
+	lastTransfer time.Time
+	lastUsbStage time.Time // start of the last usb transfer for the inter transfer delay
+
+	statsMutex sync.Mutex // guards stats, retries are counted outside of usbMutex
+	stats      StLinkStats
+
+	keepAliveStop chan struct{}
+
+	config StLinkInterfaceConfig // effective configuration of the handle
 }
 
 type StLinkInterfaceConfig struct {
@@ -69,6 +112,13 @@ type StLinkInterfaceConfig struct {
 	serial            string
 	initialSpeed      uint32
 	connectUnderReset bool
+	leaveExistingMode bool
+	haltOnConnect     bool
+	targetEndian      Endian
+	skipVoltageCheck  bool
+	verifyTarget      func(idcode uint32) error
+
+	interTransferDelay time.Duration
 }
 
 func NewStLinkConfig(vid gousb.ID, pid gousb.ID, mode StLinkMode,
@@ -81,65 +131,188 @@ func NewStLinkConfig(vid gousb.ID, pid gousb.ID, mode StLinkMode,
 		serial:            serial,
 		initialSpeed:      initialSpeed,
 		connectUnderReset: connectUnderReset,
+		leaveExistingMode: true,
 	}
 
 	return config
 }
 
+// SetLeaveExistingMode controls whether the current probe mode is left before
+// the requested one is entered. Disable it to attach to an already running
+// debug session without perturbing the target.
+func (c *StLinkInterfaceConfig) SetLeaveExistingMode(leave bool) *StLinkInterfaceConfig {
+	c.leaveExistingMode = leave
+	return c
+}
+
+func (c *StLinkInterfaceConfig) Vid() gousb.ID {
+	return c.vid
+}
+
+func (c *StLinkInterfaceConfig) Pid() gousb.ID {
+	return c.pid
+}
+
+func (c *StLinkInterfaceConfig) Mode() StLinkMode {
+	return c.mode
+}
+
+func (c *StLinkInterfaceConfig) Serial() string {
+	return c.serial
+}
+
+func (c *StLinkInterfaceConfig) InitialSpeed() uint32 {
+	return c.initialSpeed
+}
+
+func (c *StLinkInterfaceConfig) ConnectUnderReset() bool {
+	return c.connectUnderReset
+}
+
+func (c *StLinkInterfaceConfig) LeaveExistingMode() bool {
+	return c.leaveExistingMode
+}
+
+func (c *StLinkInterfaceConfig) HaltOnConnect() bool {
+	return c.haltOnConnect
+}
+
+func (c *StLinkInterfaceConfig) TargetEndian() Endian {
+	return c.targetEndian
+}
+
+func (c *StLinkInterfaceConfig) SkipVoltageCheck() bool {
+	return c.skipVoltageCheck
+}
+
+// SetSkipVoltageCheck skips measuring the target voltage while connecting, for
+// boards without a connected voltage sense line
+func (c *StLinkInterfaceConfig) SetSkipVoltageCheck(skip bool) *StLinkInterfaceConfig {
+	c.skipVoltageCheck = skip
+	return c
+}
+
+func (c *StLinkInterfaceConfig) VerifyTarget() func(idcode uint32) error {
+	return c.verifyTarget
+}
+
+// SetVerifyTarget sets a hook called with the dp idcode right after the debug
+// mode is entered. If it returns an error, NewStLink closes the st-link again
+// and fails with it. Pass nil to remove the hook.
+func (c *StLinkInterfaceConfig) SetVerifyTarget(verify func(idcode uint32) error) *StLinkInterfaceConfig {
+	c.verifyTarget = verify
+	return c
+}
+
+func (c *StLinkInterfaceConfig) InterTransferDelay() time.Duration {
+	return c.interTransferDelay
+}
+
+// SetInterTransferDelay sets the minimum time between the starts of two usb
+// transfers (command and data stages), for hosts and hubs dropping data on back
+// to back transfers.
+// The default is 0. Earlier versions always waited 10ms before the data stage
+// of outgoing transfers, set 10ms to get that behavior back.
+func (c *StLinkInterfaceConfig) SetInterTransferDelay(delay time.Duration) *StLinkInterfaceConfig {
+	c.interTransferDelay = delay
+	return c
+}
+
+// SetTargetEndian sets the byte order used to decode words of target memory
+// (ReadMemU32Slice, WriteMemU32Slice and rtt), default is little endian.
+// Debug and system registers are always accessed little endian.
+func (c *StLinkInterfaceConfig) SetTargetEndian(e Endian) *StLinkInterfaceConfig {
+	c.targetEndian = e
+	return c
+}
+
+// Config returns the configuration the handle was opened with, vid, pid,
+// serial and speed hold the values of the opened st-link
+func (h *StLink) Config() StLinkInterfaceConfig {
+	return h.config
+}
+
+// ProductName returns the usb product string of the st-link, e.g. STLINK-V3SET
+func (h *StLink) ProductName() (string, error) {
+	if h.productName != "" {
+		return h.productName, nil
+	}
+
+	if h.libUsbDevice == nil {
+		return "", errors.New("st-link handle is closed")
+	}
+
+	h.usbMutex.Lock()
+	product, err := h.libUsbDevice.Product()
+	h.usbMutex.Unlock()
+
+	if err != nil {
+		return "", fmt.Errorf("could not read usb product string: %w", err)
+	}
+
+	h.productName = product
+
+	return product, nil
+}
+
+// SetHaltOnConnect halts the core while connecting. Together with connect
+// under reset the core is halted at the reset vector.
+func (c *StLinkInterfaceConfig) SetHaltOnConnect(halt bool) *StLinkInterfaceConfig {
+	c.haltOnConnect = halt
+	return c
+}
+
+// NewStLink opens and connects the st-link. A connect under reset releases
+// the reset line before returning. Unless halt on connect is configured the
+// core is left running, memory accesses do not halt the core.
 func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
 	var err error
 	var devices []*gousb.Device
 
-	handle := &StLink{}
+	handle := &StLink{config: *config}
+	connected := false
+
+	// release the usb device if opening fails half way
+	defer func() {
+		if !connected {
+			handle.usbClose()
+		}
+	}()
+
+	handle.openedAp = bitmap.New(debugAccessPortSelectionMaximum + 1)
+	handle.retryPolicy = DefaultRetryPolicy()
 
 	handle.stMode = config.mode
 
-	if config.vid == AllSupportedVIds && config.pid == AllSupportedPIds {
-		devices, err = usbFindDevices(goStLinkSupportedVIds, goStLinkSupportedPIds)
+	if config.serial != "" {
+		// open the st-links one by one and stop at the matching serial
+		handle.libUsbDevice, err = usbOpenStLinkBySerial(config)
 
-	} else if config.vid == AllSupportedVIds && config.pid != AllSupportedPIds {
-		devices, err = usbFindDevices(goStLinkSupportedVIds, []gousb.ID{config.pid})
+		if err != nil {
+			return nil, err
+		}
 
-	} else if config.vid != AllSupportedVIds && config.pid == AllSupportedPIds {
-		devices, err = usbFindDevices([]gousb.ID{config.vid}, goStLinkSupportedPIds)
+		logger.Infof("found st link with serial number %s", config.serial)
 
 	} else {
-		devices, err = usbFindDevices([]gousb.ID{config.vid}, []gousb.ID{config.pid})
-	}
+		devices, err = usbFindStLinks(config)
 
-	if len(devices) > 0 {
-		if config.serial == "" && len(devices) > 1 {
+		if len(devices) == 0 {
+			return nil, errors.New("could not find any ST-Link connected to computer")
 
+		} else if len(devices) > 1 {
 			for _, d := range devices {
 				d.Close()
 			}
 
 			return nil, errors.New("could not identity exact stlink by given parameters. (Perhaps a serial no is missing?)")
+		}
 
-		} else if len(devices) == 1 {
-			handle.libUsbDevice = devices[0]
-
-			logger.Infof("Found st-link witch matching product and vendor id [%04x, %04x]",
-				uint16(handle.libUsbDevice.Desc.Product),
-				uint16(handle.libUsbDevice.Desc.Vendor))
-
-		} else {
-			for _, dev := range devices {
-				devSerialNo, _ := dev.SerialNumber()
-
-				logger.Tracef("compare serial no %s with number %s", devSerialNo, config.serial)
-
-				if devSerialNo == config.serial {
-					handle.libUsbDevice = dev
+		handle.libUsbDevice = devices[0]
 
-					logger.Infof("found st link with serial number %s", devSerialNo)
-				} else {
-					dev.Close()
-				}
-			}
-		}
-	} else {
-		return nil, errors.New("could not find any ST-Link connected to computer")
+		logger.Infof("Found st-link witch matching product and vendor id [%04x, %04x]",
+			uint16(handle.libUsbDevice.Desc.Product),
+			uint16(handle.libUsbDevice.Desc.Vendor))
 	}
 
 	if handle.libUsbDevice == nil {
@@ -176,7 +349,9 @@ func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
 
 	switch uint16(handle.libUsbDevice.Desc.Product) {
 	case stLinkV1Pid:
-		return nil, errors.New("st-link V1 api not supported by gostlink")
+		// no trace support, commands are sent as scsi commands
+		handle.version.stlink = 1
+		handle.txEndpoint, errorTx = handle.libUsbInterface.OutEndpoint(usbTxEndpointNo)
 
 	case stLinkV3UsbLoaderPid, stLinkV3EPid, stLinkV3SPid, stLinkV32VcpPid:
 		handle.version.stlink = 3
@@ -188,6 +363,11 @@ func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
 		handle.txEndpoint, errorTx = handle.libUsbInterface.OutEndpoint(usbTxEndpointApi2v1)
 		handle.traceEndpoint, errorTrace = handle.libUsbInterface.InEndpoint(usbTraceEndpointApi2v1)
 
+	case stLinkV2Pid:
+		handle.version.stlink = 2
+		handle.txEndpoint, errorTx = handle.libUsbInterface.OutEndpoint(usbTxEndpointNo)
+		handle.traceEndpoint, errorTrace = handle.libUsbInterface.InEndpoint(usbTraceEndpointNo)
+
 	default:
 		logger.Infof("unknown product id of debugger %x. Assuming Link V2 api", uint16(handle.libUsbDevice.Desc.Product))
 		handle.version.stlink = 2
@@ -228,12 +408,18 @@ func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
 		return nil, errors.New("unknown ST-Link mode")
 	}
 
-	err = handle.usbInitMode(config.connectUnderReset, config.initialSpeed)
+	err = handle.usbInitMode(config)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if config.verifyTarget != nil {
+		if err = handle.verifyTarget(config.verifyTarget); err != nil {
+			return nil, err
+		}
+	}
+
 	/**
 		TODO: Implement SWIM mode configuration
 	if (h->st_mode == STLINK_MODE_DEBUG_SWIM) {
@@ -250,21 +436,28 @@ func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
 
 	handle.maxMemPacket = 1 << 10
 
-	err = handle.usbInitAccessPort(0)
+	err = handle.usbOpenAccessPort(0)
 
 	if err != nil {
 		return nil, err
 	}
 
+	var cpuid uint32
+
 	buffer := bytes.NewBuffer([]byte{})
-	errCode := handle.usbReadMem32(cpuIdBaseRegister, 4, buffer)
+	errCode := handle.usbReadMem32(context.Background(), 0, cpuIdBaseRegister, 4, buffer)
+
+	if errCode == nil {
+		cpuid, errCode = tryConvertToUint32(buffer.Bytes(), littleEndian)
+	}
 
 	if errCode == nil {
-		var cpuid uint32 = convertToUint32(buffer.Bytes(), littleEndian)
 		var i uint32 = (cpuid >> 4) & 0xf
 
 		logger.Debugf("got cpu id [%08x]", cpuid)
 
+		handle.cpuId = cpuid
+
 		if i == 4 || i == 3 {
 			/* Cortex-M3/M4 has 4096 bytes autoincrement range */
 			logger.Debug("set memory packet layout according to Cortex M3/M4")
@@ -275,28 +468,108 @@ func NewStLink(config *StLinkInterfaceConfig) (*StLink, error) {
 	}
 
 	logger.Debugf("using TAR autoincrement: %d", handle.maxMemPacket)
+
+	err = handle.connectCore(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	handle.config.vid = handle.vid
+	handle.config.pid = handle.pid
+	handle.config.serial = handle.serialNo
+
+	connected = true
+
+	registerHandle()
+
+	logger.WithFields(handle.logFields()).WithFields(logrus.Fields{
+		"vid":     fmt.Sprintf("%04x", uint16(handle.vid)),
+		"pid":     fmt.Sprintf("%04x", uint16(handle.pid)),
+		"version": fmt.Sprintf("V%dJ%d", handle.version.stlink, handle.version.jtag),
+	}).Debug("st-link device opened")
+
 	return handle, nil
 }
 
 func (h *StLink) Close() {
+	h.DisableKeepAlive()
+
 	if h.libUsbDevice != nil {
 		logger.Debugf("close st-link device [%04x:%04x]", uint16(h.vid), uint16(h.pid))
 
+		h.usbClose()
+
+		unregisterHandle()
+	} else {
+		logger.Warn("tried to close invalid stlink handle")
+	}
+}
+
+// releases the usb interface and device of the handle
+func (h *StLink) usbClose() {
+	h.usbMutex.Lock()
+	defer h.usbMutex.Unlock()
+
+	if h.libUsbInterface != nil {
 		h.libUsbInterface.Close()
+		h.libUsbInterface = nil
+	}
+
+	if h.libUsbConfig != nil {
 		h.libUsbConfig.Close()
+		h.libUsbConfig = nil
+	}
+
+	if h.libUsbDevice != nil {
 		h.libUsbDevice.Close()
-	} else {
-		logger.Warn("tried to close invalid stlink handle")
+		h.libUsbDevice = nil
+	}
+}
+
+// reads the dp idcode and hands it to the verify hook of the config
+func (h *StLink) verifyTarget(verify func(idcode uint32) error) error {
+	idCode, err := h.GetIdCode()
+
+	if err != nil {
+		return fmt.Errorf("could not read idcode to verify target: %w", err)
 	}
+
+	logger.Debugf("verify target with idcode [%08x]", idCode)
+
+	return verify(idCode)
 }
 
+// Shutdown closes the handle and releases the libusb context, counterpart of Attach
+func (h *StLink) Shutdown() {
+	h.Close()
+	CloseUSB()
+}
+
+// GetTargetVoltage measures the target voltage, a failed reference reading is
+// retried according to the retry policy
 func (h *StLink) GetTargetVoltage() (float32, error) {
-	var adcResults [2]uint32
+	if err := h.RequireFeature("target-voltage"); err != nil {
+		return -1.0, err
+	}
+
+	retries := 0
 
-	/* no error message, simply quit with error */
-	if !h.version.flags.Get(flagHasTargetVolt) {
-		return -1.0, errors.New("device does not support voltage measurement")
+	for {
+		voltage, err := h.usbGetTargetVoltage()
+
+		if err == errVoltageReference && retries < h.retryPolicy.MaxRetries {
+			retries = h.backoffSleep(retries)
+
+			continue
+		}
+
+		return voltage, err
 	}
+}
+
+func (h *StLink) usbGetTargetVoltage() (float32, error) {
+	var adcResults [2]uint32
 
 	ctx := h.initTransfer(transferIncoming)
 
@@ -309,16 +582,19 @@ func (h *StLink) GetTargetVoltage() (float32, error) {
 	}
 
 	/* convert result */
+	if len(ctx.DataBytes()) < 8 {
+		return -1.0, errors.New("truncated target voltage response")
+	}
+
 	adcResults[0] = convertToUint32(ctx.DataBytes(), littleEndian)
 	adcResults[1] = convertToUint32(ctx.DataBytes()[4:], littleEndian)
 
-	var targetVoltage float32 = 0.0
-
-	if adcResults[0] > 0 {
-		targetVoltage = 2 * (float32(adcResults[1]) * (1.2 / float32(adcResults[0])))
+	// a zero reference reading is a measurement failure, not an unpowered target
+	if adcResults[0] == 0 {
+		return -1.0, errVoltageReference
 	}
 
-	return targetVoltage, nil
+	return 2 * (float32(adcResults[1]) * (1.2 / float32(adcResults[0]))), nil
 }
 
 func (h *StLink) GetIdCode() (uint32, error) {
@@ -341,20 +617,32 @@ func (h *StLink) GetIdCode() (uint32, error) {
 	} else {
 		ctx.cmdBuf.WriteByte(debugApiV2ReadIdCodes)
 
-		retVal = h.usbTransferErrCheck(ctx, 12)
+		// the target may still settle right after connecting
+		retVal = h.usbCmdAllowRetry(ctx, 12)
 		offset = 4
 	}
 
 	if retVal != nil {
 		return 0, retVal
 
-	} else {
-		idCode := convertToUint32(ctx.DataBytes()[offset:], littleEndian)
+	} else if len(ctx.DataBytes()) < offset {
+		return 0, errors.New("truncated id code response")
 
-		return idCode, nil
+	} else {
+		return tryConvertToUint32(ctx.DataBytes()[offset:], littleEndian)
 	}
 }
 func (h *StLink) SetSpeed(khz uint32, query bool) (uint32, error) {
+	speed, err := h.setSpeed(khz, query)
+
+	if err == nil && !query {
+		h.config.initialSpeed = speed
+	}
+
+	return speed, err
+}
+
+func (h *StLink) setSpeed(khz uint32, query bool) (uint32, error) {
 
 	switch h.stMode {
 	/*case STLINK_MODE_DEBUG_SWIM:
@@ -383,8 +671,14 @@ func (h *StLink) SetSpeed(khz uint32, query bool) (uint32, error) {
 func (h *StLink) ConfigTrace(enabled bool, tpiuProtocol TpuiPinProtocolType, portSize uint32,
 	traceFreq *uint32, traceClkInFreq uint32, preScaler *uint16) error {
 
-	if enabled == true && (!h.version.flags.Get(flagHasTrace) || tpiuProtocol != TpuiPinProtocolAsyncUart) {
-		return errors.New("the attached ST-Link version does not support this trace mode")
+	if enabled == true {
+		if err := h.RequireFeature("trace"); err != nil {
+			return err
+		}
+
+		if tpiuProtocol != TpuiPinProtocolAsyncUart {
+			return errors.New("the attached ST-Link version does not support this trace mode")
+		}
 	}
 
 	if !enabled {
@@ -414,11 +708,38 @@ func (h *StLink) ConfigTrace(enabled bool, tpiuProtocol TpuiPinProtocolType, por
 
 	*preScaler = presc
 	h.trace.sourceHz = *traceFreq
+	h.trace.clockInHz = traceClkInFreq
 
 	return h.usbTraceEnable()
 }
 
 func (h *StLink) ReadMem(addr uint32, bitLength MemoryBlockSize, count uint32, buffer *bytes.Buffer) error {
+	return h.ReadMemContext(context.Background(), addr, bitLength, count, buffer)
+}
+
+// ReadMemContext works like ReadMem, a cancelled ctx aborts the running usb
+// transfer and stops further ones
+func (h *StLink) ReadMemContext(ctx context.Context, addr uint32, bitLength MemoryBlockSize, count uint32, buffer *bytes.Buffer) error {
+	return h.memAccessError("read", 0, addr, h.readMem(ctx, 0, addr, bitLength, count, buffer))
+}
+
+// ReadMemAP reads memory through the given access port, e.g. the AP of a second
+// core. Access ports other than 0 require V2J32/V3J2 firmware.
+func (h *StLink) ReadMemAP(apsel uint16, addr uint32, bitLength MemoryBlockSize, count uint32, buffer *bytes.Buffer) error {
+	if apsel != 0 {
+		if err := h.RequireFeature("multi-ap-mem"); err != nil {
+			return err
+		}
+	}
+
+	if err := h.usbOpenAccessPort(apsel); err != nil {
+		return err
+	}
+
+	return h.memAccessError("read", byte(apsel), addr, h.readMem(context.Background(), byte(apsel), addr, bitLength, count, buffer))
+}
+
+func (h *StLink) readMem(parent context.Context, ap byte, addr uint32, bitLength MemoryBlockSize, count uint32, buffer *bytes.Buffer) error {
 	var retErr error
 	var bytesRemaining uint32 = 0
 	var retries int = 0
@@ -429,11 +750,21 @@ func (h *StLink) ReadMem(addr uint32, bitLength MemoryBlockSize, count uint32, b
 
 	/* switch to 8 bit if stlink does not support 16 bit memory read */
 	if bitLength == Memory16BitBlock && (!h.version.flags.Get(flagHasMem16Bit)) {
+		if h.strictAccessWidth {
+			return ErrMem16Unsupported
+		}
+
 		bitLength = Memory8BitBlock
 		logger.Debug("st-link does not support 16bit transfer")
 	}
 
 	for count > 0 {
+		if err := parent.Err(); err != nil {
+			return err
+		}
+
+		// the data of a failed read is dropped before it is retried
+		start := buffer.Len()
 
 		if bitLength != Memory8BitBlock {
 			bytesRemaining = h.maxBlockSize(h.maxMemPacket, addr)
@@ -467,16 +798,14 @@ func (h *StLink) ReadMem(addr uint32, bitLength MemoryBlockSize, count uint32, b
 
 				logger.Trace("read unaligned bytes")
 
-				err := h.usbReadMem8(addr, uint16(headBytes), buffer)
+				err := h.usbReadMem8(parent, ap, addr, uint16(headBytes), buffer)
 
 				if err != nil {
-					usbError := err.(*usbError)
+					buffer.Truncate(start)
 
-					if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-						var sleepDur time.Duration = 1 << retries
-						retries++
+					if isWaitError(err) && retries < h.retryPolicy.MaxRetries {
+						retries = h.backoffSleep(retries)
 
-						time.Sleep(sleepDur * 1000000)
 						continue
 					}
 
@@ -487,29 +816,28 @@ func (h *StLink) ReadMem(addr uint32, bitLength MemoryBlockSize, count uint32, b
 				addr += headBytes
 				count -= headBytes
 				bytesRemaining -= headBytes
+				start = buffer.Len()
 
 				logger.Tracef("BufPos: %d, Addr: %08x, Count: %d, BytesRemain: %d", bufferPos, addr, count, bytesRemaining)
 			}
 
 			if (bytesRemaining & (uint32(bitLength) - 1)) > 0 {
-				retErr = h.ReadMem(addr, 1, bytesRemaining, buffer)
+				retErr = h.readMem(parent, ap, addr, 1, bytesRemaining, buffer)
 			} else if bitLength == Memory16BitBlock {
-				retErr = h.usbReadMem16(addr, uint16(bytesRemaining), buffer)
+				retErr = h.usbReadMem16(parent, ap, addr, uint16(bytesRemaining), buffer)
 			} else {
-				retErr = h.usbReadMem32(addr, uint16(bytesRemaining), buffer)
+				retErr = h.usbReadMem32(parent, ap, addr, uint16(bytesRemaining), buffer)
 			}
 		} else {
-			retErr = h.usbReadMem8(addr, uint16(bytesRemaining), buffer)
+			retErr = h.usbReadMem8(parent, ap, addr, uint16(bytesRemaining), buffer)
 		}
 
 		if retErr != nil {
-			usbError := retErr.(*usbError)
+			buffer.Truncate(start)
 
-			if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-				var sleepDur time.Duration = 1 << retries
-				retries++
+			if isWaitError(retErr) && retries < h.retryPolicy.MaxRetries {
+				retries = h.backoffSleep(retries)
 
-				time.Sleep(sleepDur * 1000000)
 				continue
 			}
 
@@ -524,7 +852,160 @@ func (h *StLink) ReadMem(addr uint32, bitLength MemoryBlockSize, count uint32, b
 	return retErr
 }
 
+func (h *StLink) ReadMemU32Slice(addr uint32, count uint32) ([]uint32, error) {
+	return h.readMemU32Slice(addr, count, h.config.targetEndian)
+}
+
+func (h *StLink) readMemU32Slice(addr uint32, count uint32, e Endian) ([]uint32, error) {
+	if (addr % 4) > 0 {
+		return nil, errors.New("address must be word aligned for 32bit memory read")
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+
+	err := h.ReadMem(addr, Memory32BitBlock, count, buffer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]uint32, count)
+	data := buffer.Bytes()
+
+	for i := range values {
+		values[i] = convertToUint32(data[i*4:], e)
+	}
+
+	return values, nil
+}
+
+// ReadBytes reads exactly n bytes starting at addr. The word aligned part is
+// read with 32bit access, unaligned head and tail bytes with 8bit access.
+func (h *StLink) ReadBytes(addr uint32, n uint32) ([]byte, error) {
+	buffer := bytes.NewBuffer(make([]byte, 0, n))
+
+	if err := h.readBytes(context.Background(), addr, n, buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// ReadCString reads a null terminated string of at most maxLen bytes from addr.
+// Without terminator within maxLen bytes the truncated string is returned
+// together with an error.
+func (h *StLink) ReadCString(addr uint32, maxLen uint32) (string, error) {
+	data, err := h.ReadBytes(addr, maxLen)
+
+	if err != nil {
+		return "", err
+	}
+
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		return string(data[:end]), nil
+	}
+
+	return string(data), fmt.Errorf("no string terminator within %d bytes at 0x%08x", maxLen, addr)
+}
+
+// ReadMemInto reads len(dst) bytes starting at addr directly into dst, access
+// widths are chosen like in ReadBytes
+func (h *StLink) ReadMemInto(addr uint32, dst []byte) error {
+	if uint64(len(dst)) > math.MaxUint32 {
+		return errors.New("destination exceeds 32bit address space")
+	}
+
+	buffer := bytes.NewBuffer(dst[:0])
+
+	if err := h.readBytes(context.Background(), addr, uint32(len(dst)), buffer); err != nil {
+		return err
+	}
+
+	// a grown buffer would have left dst behind
+	if len(dst) > 0 && &buffer.Bytes()[0] != &dst[0] {
+		return errors.New("read overflowed destination buffer")
+	}
+
+	return nil
+}
+
+func (h *StLink) readBytes(parent context.Context, addr uint32, n uint32, buffer *bytes.Buffer) error {
+	head := (4 - (addr % 4)) % 4
+
+	if head > n {
+		head = n
+	}
+
+	words := (n - head) / 4
+	tail := n - head - words*4
+
+	if head > 0 {
+		if err := h.ReadMemContext(parent, addr, Memory8BitBlock, head, buffer); err != nil {
+			return err
+		}
+	}
+
+	if words > 0 {
+		if err := h.ReadMemContext(parent, addr+head, Memory32BitBlock, words, buffer); err != nil {
+			return err
+		}
+	}
+
+	if tail > 0 {
+		if err := h.ReadMemContext(parent, addr+head+words*4, Memory8BitBlock, tail, buffer); err != nil {
+			return err
+		}
+	}
+
+	if uint32(buffer.Len()) != n {
+		return fmt.Errorf("read %d bytes instead of %d requested bytes", buffer.Len(), n)
+	}
+
+	return nil
+}
+
+// system and debug registers of cortex-m cores are always little endian
+func (h *StLink) readUint32(addr uint32) (uint32, error) {
+	values, err := h.readMemU32Slice(addr, 1, littleEndian)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return values[0], nil
+}
+
+func (h *StLink) writeUint32(addr uint32, value uint32) error {
+	return h.writeMemU32Slice(addr, []uint32{value}, littleEndian)
+}
+
 func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
+	return h.WriteMemContext(context.Background(), address, bitLength, count, buffer)
+}
+
+// WriteMemContext works like WriteMem, a cancelled ctx aborts the running usb
+// transfer and stops further ones
+func (h *StLink) WriteMemContext(ctx context.Context, address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
+	return h.memAccessError("write", 0, address, h.writeMem(ctx, 0, address, bitLength, count, buffer))
+}
+
+// WriteMemAP writes memory through the given access port. Access ports other
+// than 0 require V2J32/V3J2 firmware.
+func (h *StLink) WriteMemAP(apsel uint16, address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
+	if apsel != 0 {
+		if err := h.RequireFeature("multi-ap-mem"); err != nil {
+			return err
+		}
+	}
+
+	if err := h.usbOpenAccessPort(apsel); err != nil {
+		return err
+	}
+
+	return h.memAccessError("write", byte(apsel), address, h.writeMem(context.Background(), byte(apsel), address, bitLength, count, buffer))
+}
+
+func (h *StLink) writeMem(parent context.Context, ap byte, address uint32, bitLength MemoryBlockSize, count uint32, buffer []byte) error {
 	var retError error
 	var bytesRemaining uint32
 	retries := 0
@@ -533,11 +1014,19 @@ func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint3
 	count *= uint32(bitLength)
 
 	if bitLength == Memory16BitBlock && (!h.version.flags.Get(flagHasMem16Bit)) {
-		logger.Debug("set 16bit memory read to 8bit")
+		if h.strictAccessWidth {
+			return ErrMem16Unsupported
+		}
+
+		logger.Debug("set 16bit memory write to 8bit")
 		bitLength = Memory8BitBlock
 	}
 
 	for count > 0 {
+		if err := parent.Err(); err != nil {
+			return err
+		}
+
 		if bitLength != Memory8BitBlock {
 			bytesRemaining = h.maxBlockSize(h.maxMemPacket, address)
 		} else {
@@ -568,16 +1057,12 @@ func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint3
 			if (address & (uint32(bitLength) - 1)) > 0 {
 				var headBytes = uint32(bitLength) - (address & (uint32(bitLength) - 1))
 
-				err := h.usbWriteMem8(address, uint16(headBytes), buffer)
+				err := h.usbWriteMem8(parent, ap, address, uint16(headBytes), buffer[bufferPos:])
 
 				if err != nil {
-					usbError := err.(*usbError)
-
-					if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-						var sleepDur time.Duration = 1 << retries
-						retries++
+					if (isWaitError(err) || isWriteVerifyError(err)) && retries < h.retryPolicy.MaxRetries {
+						retries = h.backoffSleep(retries)
 
-						time.Sleep(sleepDur * 1000000)
 						continue
 					}
 
@@ -593,37 +1078,29 @@ func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint3
 			}
 
 			if (bytesRemaining & (uint32(bitLength) - 1)) > 0 {
-				retError = h.WriteMem(address, 1, bytesRemaining, buffer[bufferPos:])
+				retError = h.writeMem(parent, ap, address, 1, bytesRemaining, buffer[bufferPos:])
 			} else if bitLength == Memory16BitBlock {
-				retError = h.usbWriteMem16(address, uint16(bytesRemaining), buffer[bufferPos:])
+				retError = h.usbWriteMem16(parent, ap, address, uint16(bytesRemaining), buffer[bufferPos:])
 			} else {
-				retError = h.usbWriteMem32(address, uint16(bytesRemaining), buffer[bufferPos:])
+				retError = h.usbWriteMem32(parent, ap, address, uint16(bytesRemaining), buffer[bufferPos:])
 			}
 		} else {
-			retError = h.usbWriteMem8(address, uint16(bytesRemaining), buffer)
+			retError = h.usbWriteMem8(parent, ap, address, uint16(bytesRemaining), buffer[bufferPos:])
 		}
 
 		if retError != nil {
-			switch retError.(type) {
-			case gousb.TransferStatus:
+			if _, ok := retError.(gousb.TransferStatus); ok && retries < h.retryPolicy.MaxRetries {
 				logger.Error("got usb transfer error state ", retError)
 
-				var sleepDur time.Duration = 1 << retries
-				retries++
+				retries = h.backoffSleep(retries)
 
-				time.Sleep(sleepDur * 1000000)
 				continue
+			}
 
-			case *usbError:
-				usbError := retError.(*usbError)
-
-				if usbError.UsbErrorCode == usbErrorWait && retries < maximumWaitRetries {
-					var sleepDur time.Duration = 1 << retries
-					retries++
+			if (isWaitError(retError) || isWriteVerifyError(retError)) && retries < h.retryPolicy.MaxRetries {
+				retries = h.backoffSleep(retries)
 
-					time.Sleep(sleepDur * 1000000)
-					continue
-				}
+				continue
 			}
 
 			return retError
@@ -637,9 +1114,170 @@ func (h *StLink) WriteMem(address uint32, bitLength MemoryBlockSize, count uint3
 	return retError
 }
 
+// SetVerifyWrites makes memory writes retry on write verify errors reported by
+// the st-link before failing, without it such errors are returned right away
+func (h *StLink) SetVerifyWrites(verify bool) {
+	h.verifyWrites = verify
+}
+
+// SetStrictAccessWidth makes 16bit memory accesses fail with ErrMem16Unsupported
+// on st-links without 16bit support instead of falling back to byte access
+func (h *StLink) SetStrictAccessWidth(strict bool) {
+	h.strictAccessWidth = strict
+}
+
+// WriteBytes writes all bytes of data starting at addr. The word aligned part
+// is written with 32bit access, unaligned head and tail bytes with 8bit access.
+func (h *StLink) WriteBytes(addr uint32, data []byte) error {
+	n := uint32(len(data))
+	head := (4 - (addr % 4)) % 4
+
+	if head > n {
+		head = n
+	}
+
+	words := (n - head) / 4
+	tail := n - head - words*4
+
+	if head > 0 {
+		if err := h.WriteMem(addr, Memory8BitBlock, head, data); err != nil {
+			return err
+		}
+	}
+
+	if words > 0 {
+		if err := h.WriteMem(addr+head, Memory32BitBlock, words, data[head:]); err != nil {
+			return err
+		}
+	}
+
+	if tail > 0 {
+		return h.WriteMem(addr+head+words*4, Memory8BitBlock, tail, data[head+words*4:])
+	}
+
+	return nil
+}
+
+// ReadMem8Strict reads count bytes starting at addr with 8bit accesses only,
+// for peripherals which must not be accessed with a wider bus width
+func (h *StLink) ReadMem8Strict(addr uint32, count uint32) ([]byte, error) {
+	buffer := bytes.NewBuffer(make([]byte, 0, count))
+	retries := 0
+
+	for count > 0 {
+		chunk := count
+
+		if chunk > h.usbBlock() {
+			chunk = h.usbBlock()
+		}
+
+		start := buffer.Len()
+		err := h.usbReadMem8(context.Background(), 0, addr, uint16(chunk), buffer)
+
+		if err != nil {
+			buffer.Truncate(start)
+
+			if isWaitError(err) && retries < h.retryPolicy.MaxRetries {
+				retries = h.backoffSleep(retries)
+
+				continue
+			}
+
+			return nil, h.memAccessError("read", 0, addr, err)
+		}
+
+		addr += chunk
+		count -= chunk
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// WriteMem8Strict writes data starting at addr with 8bit accesses only
+func (h *StLink) WriteMem8Strict(addr uint32, data []byte) error {
+	retries := 0
+
+	for len(data) > 0 {
+		chunk := uint32(len(data))
+
+		if chunk > h.usbBlock() {
+			chunk = h.usbBlock()
+		}
+
+		err := h.usbWriteMem8(context.Background(), 0, addr, uint16(chunk), data[:chunk])
+
+		if err != nil {
+			if (isWaitError(err) || isWriteVerifyError(err)) && retries < h.retryPolicy.MaxRetries {
+				retries = h.backoffSleep(retries)
+
+				continue
+			}
+
+			return h.memAccessError("write", 0, addr, err)
+		}
+
+		addr += chunk
+		data = data[chunk:]
+	}
+
+	return nil
+}
+
+func (h *StLink) WriteMemU32Slice(addr uint32, values []uint32) error {
+	return h.writeMemU32Slice(addr, values, h.config.targetEndian)
+}
+
+func (h *StLink) writeMemU32Slice(addr uint32, values []uint32, e Endian) error {
+	if (addr % 4) > 0 {
+		return errors.New("address must be word aligned for 32bit memory write")
+	}
+
+	buffer := NewBuffer(len(values) * 4)
+
+	for _, v := range values {
+		buffer.WriteUint32(v, e)
+	}
+
+	return h.WriteMem(addr, Memory32BitBlock, uint32(len(values)), buffer.Bytes())
+}
+
 func (h *StLink) PollTrace(buffer []byte, size *uint32) error {
+	return h.PollTraceTimeout(buffer, size, 0)
+}
+
+// PollTraceTimeout waits up to timeout for trace data instead of returning
+// immediately if the st-link has no trace bytes buffered
+func (h *StLink) PollTraceTimeout(buffer []byte, size *uint32, timeout time.Duration) error {
+	if *size > uint32(len(buffer)) {
+		*size = uint32(len(buffer))
+	}
 
-	if h.trace.enabled == true && h.version.flags.Get(flagHasTrace) {
+	bytesRead, err := h.readTrace(buffer[:*size], timeout)
+
+	*size = uint32(bytesRead)
+
+	return err
+}
+
+// ReadTrace returns up to max bytes of buffered trace data. If data was lost
+// ErrTraceOverflow is returned together with the bytes read.
+func (h *StLink) ReadTrace(max int) ([]byte, error) {
+	buffer := make([]byte, max)
+
+	bytesRead, err := h.readTrace(buffer, 0)
+
+	return buffer[:bytesRead], err
+}
+
+func (h *StLink) readTrace(buffer []byte, timeout time.Duration) (int, error) {
+
+	if h.trace.enabled == false || !h.version.flags.Get(flagHasTrace) || len(buffer) == 0 {
+		return 0, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
 		ctx := h.initTransfer(transferIncoming)
 
 		ctx.cmdBuf.WriteByte(cmdDebug)
@@ -648,24 +1286,51 @@ func (h *StLink) PollTrace(buffer []byte, size *uint32) error {
 		err := h.usbTransferNoErrCheck(ctx, 2)
 
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		bytesAvailable := uint32(ctx.dataBuf.ReadUint16LE())
+		traceBytes, err := tryConvertToUint16(ctx.DataBytes(), littleEndian)
 
-		if bytesAvailable < *size {
-			*size = bytesAvailable
-		} else {
-			*size = *size - 1
+		if err != nil {
+			return 0, err
+		}
+
+		bytesAvailable := uint32(traceBytes)
+
+		if bytesAvailable == 0 {
+			remaining := time.Until(deadline)
+
+			if remaining <= 0 {
+				return 0, nil
+			}
+
+			if remaining > tracePollInterval {
+				remaining = tracePollInterval
+			}
+
+			time.Sleep(remaining)
+			continue
 		}
 
-		if *size > 0 {
-			return h.usbReadTrace(buffer, *size)
+		size := uint32(len(buffer))
+
+		if bytesAvailable < size {
+			size = bytesAvailable
 		}
-	}
 
-	*size = 0
-	return nil
+		if timeout < usbReadTimeout {
+			timeout = usbReadTimeout
+		}
+
+		bytesRead, err := h.usbReadTrace(buffer, size, timeout)
+
+		if err == nil && bytesAvailable >= traceSize {
+			logger.Warnf("trace buffer overflow, %d bytes buffered", bytesAvailable)
+			err = ErrTraceOverflow
+		}
+
+		return bytesRead, err
+	}
 }
 
 func (h *StLink) Reset() {
@@ -5,7 +5,10 @@
 package gostlink
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 )
 
 type TraceConfigType int
@@ -35,6 +38,9 @@ const (
 
 const tpuiAcprMaxSwoScaler = 0x1fff
 
+// interval between polls of the trace byte count while waiting for data
+const tracePollInterval = 10 * time.Millisecond
+
 func (h *StLink) usbTraceDisable() error {
 
 	if !h.version.flags.Get(flagHasTrace) {
@@ -82,17 +88,295 @@ func (h *StLink) usbTraceEnable() error {
 	}
 }
 
-func (h *StLink) usbReadTrace(buffer []byte, size uint32) error {
+func (h *StLink) usbReadTrace(buffer []byte, size uint32, timeout time.Duration) (int, error) {
 	if !h.version.flags.Get(flagHasTrace) {
-		return errors.New("trace is not supported by connected device")
+		return 0, errors.New("trace is not supported by connected device")
+	}
+
+	if h.disconnected {
+		return 0, ErrProbeDisconnected
 	}
 
-	bytesRead, err := usbRawRead(h.traceEndpoint, buffer)
+	bytesRead, err := h.usbReadTraceEndpoint(context.Background(), buffer[:size], timeout)
+
+	// the st-link reported pending trace data, nothing arriving on the endpoint
+	// means it was selected wrong for the firmware
+	if (err != nil && !errors.Is(err, ErrProbeDisconnected) || bytesRead == 0) && !h.trace.endpointVerified {
+		if altRead, altErr := h.usbReadTraceAltEndpoint(buffer[:size], timeout); altErr == nil {
+			bytesRead, err = altRead, nil
+		}
+	}
 
 	if err != nil {
-		return err
+		return 0, h.checkDisconnected(err)
 	} else {
+		if bytesRead > 0 {
+			h.trace.endpointVerified = true
+		}
+
 		logger.Debugf("Read [%d from %d] bytes from trace channel", bytesRead, size)
-		return nil
+		return bytesRead, nil
+	}
+}
+
+// tries the trace endpoint number used by the other st-link variants and keeps
+// it if data arrives there
+func (h *StLink) usbReadTraceAltEndpoint(buffer []byte, timeout time.Duration) (int, error) {
+	if h.traceEndpoint == nil {
+		return 0, errors.New("no trace endpoint to switch from")
+	}
+
+	altNo := usbTraceEndpointNo
+
+	if h.traceEndpoint.Desc.Number == usbTraceEndpointNo&^usbEndpointIn {
+		altNo = usbTraceEndpointApi2v1
+	}
+
+	altEndpoint, err := h.libUsbInterface.InEndpoint(altNo)
+
+	if err != nil {
+		return 0, err
+	}
+
+	bytesRead, err := usbRawReadTimeout(context.Background(), altEndpoint, buffer, timeout)
+
+	if err != nil || bytesRead == 0 {
+		return 0, errors.New("no trace data on alternate endpoint")
+	}
+
+	logger.Warnf("trace data received on endpoint %d instead of %d, switching trace endpoint",
+		altEndpoint.Desc.Number, h.traceEndpoint.Desc.Number)
+
+	h.traceEndpoint = altEndpoint
+
+	return bytesRead, nil
+}
+
+// TraceEndpoint returns the number of the usb endpoint trace data is read from
+func (h *StLink) TraceEndpoint() int {
+	if h.traceEndpoint == nil {
+		return -1
+	}
+
+	return h.traceEndpoint.Desc.Number
+}
+
+const (
+	traceClockMeasureTime = 100 * time.Millisecond
+
+	dbgMcuCrRegister  = 0xE0042004
+	dbgMcuCrTraceIoEn = 1 << 5
+)
+
+// AutoConfigTrace configures swo output at desiredBaud (0 for the maximum) with
+// the trace clock found by TraceClock, hseHz is the frequency of the external
+// oscillator of the target (0 if unknown). The trace port of the target (TPIU
+// and the stm32 trace pin) is set up as well, only ITM configuration is left to
+// the caller.
+func (h *StLink) AutoConfigTrace(enabled bool, desiredBaud uint32, hseHz uint32) error {
+	baud := desiredBaud
+
+	if !enabled {
+		return h.ConfigTrace(false, TpuiPinProtocolAsyncUart, 1, &baud, 0, nil)
+	}
+
+	clock, err := h.TraceClock(hseHz)
+
+	if err != nil {
+		return err
+	}
+
+	var prescaler uint16
+
+	if err = h.ConfigTrace(true, TpuiPinProtocolAsyncUart, 1, &baud, clock, &prescaler); err != nil {
+		return err
+	}
+
+	if prescaler == 0 {
+		return fmt.Errorf("no swo prescaler found for %d baud with trace clock %d Hz", baud, clock)
+	}
+
+	logger.Debugf("swo at %d baud with trace clock %d Hz (prescaler %d)", baud, clock, prescaler)
+
+	if device, err := h.DetectDevice(); err == nil {
+		// the trace pin is enabled in DBGMCU_CR on all families with the DBGMCU
+		// on the private peripheral bus (all but the cortex-m0 parts and STM32H7)
+		if layout, ok := dbgMcuFreezeLayouts[device.Family]; ok && (layout.apb1&0xFFFFF000) == 0xE0042000 {
+			if err := h.modifyRegister(dbgMcuCrRegister, dbgMcuCrTraceIoEn, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	// TraceClock leaves the trace blocks as it found them, the TPIU needs them
+	if err = h.modifyDemcr(demcrTrcEna, true); err != nil {
+		return err
+	}
+
+	if err = h.writeUint32(tpiuSpprRegister, tpiuSpprNrz); err != nil {
+		return err
+	}
+
+	if err = h.writeUint32(tpiuFfcrRegister, tpiuFfcrTrigIn); err != nil {
+		return err
+	}
+
+	return h.writeUint32(tpiuAcprRegister, uint32(prescaler)-1)
+}
+
+// TraceClock returns the trace clock (core clock on stm32 devices). It is read
+// from the rcc registers of the device found by DetectDevice, hseHz is the
+// frequency of the external oscillator of the target (0 if unknown).
+//
+// For other targets, clock trees not decoded (e.g. STM32H7) or an unknown hse
+// the clock is measured with the DWT cycle counter instead, see
+// MeasureTraceClock.
+func (h *StLink) TraceClock(hseHz uint32) (uint32, error) {
+	if _, err := h.DetectDevice(); err != nil {
+		if errors.Is(err, ErrProbeDisconnected) {
+			return 0, err
+		}
+
+		logger.Debugf("measure trace clock of a target without stm32 device id: %v", err)
+		return h.MeasureTraceClock()
 	}
+
+	clock, err := h.RccClock(hseHz)
+
+	if errors.Is(err, errClockNotDecoded) {
+		logger.Debugf("measure trace clock, %v", err)
+		return h.MeasureTraceClock()
+	}
+
+	return clock, err
+}
+
+// MeasureTraceClock estimates the trace clock with the DWT cycle counter. The
+// core has to be running and awake, the counter stops while the core sleeps.
+//
+// The counter is sampled over 100ms with host timestamps taken around the usb
+// transfers, the usb latency limits the accuracy to about 1%, the result is
+// rounded to 10 kHz. Sleeping is only seen at the samples, a core sleeping in
+// between or changing the clock during the measurement gives wrong results.
+// DEMCR and DWT_CTRL are restored afterwards.
+func (h *StLink) MeasureTraceClock() (clock uint32, err error) {
+	if err = h.checkCoreAwake(); err != nil {
+		return 0, err
+	}
+
+	demcr, err := h.readUint32(demcrRegister)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if (demcr & demcrTrcEna) == 0 {
+		if err = h.writeUint32(demcrRegister, demcr|demcrTrcEna); err != nil {
+			return 0, err
+		}
+
+		defer h.restoreRegister(demcrRegister, demcr, &err)
+	}
+
+	dwtCtrl, err := h.readUint32(dwtCtrlRegister)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if (dwtCtrl & dwtCtrlNoCycCnt) > 0 {
+		return 0, errors.New("core has no cycle counter to measure the trace clock")
+	}
+
+	if (dwtCtrl & dwtCtrlCycCntEna) == 0 {
+		if err = h.writeUint32(dwtCtrlRegister, dwtCtrl|dwtCtrlCycCntEna); err != nil {
+			return 0, err
+		}
+
+		defer h.restoreRegister(dwtCtrlRegister, dwtCtrl, &err)
+	}
+
+	startCycles, startTime, err := h.sampleCycleCounter()
+
+	if err != nil {
+		return 0, err
+	}
+
+	time.Sleep(traceClockMeasureTime)
+
+	endCycles, endTime, err := h.sampleCycleCounter()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = h.checkCoreAwake(); err != nil {
+		return 0, err
+	}
+
+	hz := float64(endCycles-startCycles) / endTime.Sub(startTime).Seconds()
+
+	// round to 10 kHz, the measurement is not more precise anyway
+	return uint32(hz/10000+0.5) * 10000, nil
+}
+
+// the cycle counter only counts while the core runs and is not sleeping
+func (h *StLink) checkCoreAwake() error {
+	dhcsr, err := h.readUint32(dhcsrRegister)
+
+	if err != nil {
+		return err
+	}
+
+	if (dhcsr & (dhcsrSHalt | dhcsrSLockup)) > 0 {
+		return errors.New("measuring the trace clock requires a running core")
+	}
+
+	if (dhcsr & dhcsrSSleep) > 0 {
+		return errors.New("core is sleeping, cycle counter does not count the trace clock")
+	}
+
+	return nil
+}
+
+// writes back a register changed for a measurement, a failure is reported in
+// err unless the measurement failed already
+func (h *StLink) restoreRegister(address uint32, value uint32, err *error) {
+	if restoreErr := h.writeUint32(address, value); restoreErr != nil && *err == nil {
+		*err = restoreErr
+	}
+}
+
+// reads the cycle counter, the host time is taken in the middle of the transfer
+func (h *StLink) sampleCycleCounter() (uint32, time.Time, error) {
+	before := time.Now()
+	cycles, err := h.readUint32(dwtCycCntRegister)
+	after := time.Now()
+
+	return cycles, before.Add(after.Sub(before) / 2), err
+}
+
+// EnableItm unlocks and enables the ITM with the stimulus ports set in
+// stimulusPortMask, all ports are accessible unprivileged. Together with
+// ConfigTrace (or AutoConfigTrace) ITM output is sent over swo.
+func (h *StLink) EnableItm(stimulusPortMask uint32) error {
+	if err := h.modifyDemcr(demcrTrcEna, true); err != nil {
+		return err
+	}
+
+	return h.WriteRegisters([]RegWrite{
+		{itmLarRegister, itmLarUnlockKey},
+		{itmTcrRegister, itmTcrItmEna | itmTcrSyncEna | itmTcrTxEna | itmTcrBusId},
+		{itmTprRegister, 0},
+		{itmTerRegister, stimulusPortMask},
+	})
+}
+
+// DisableItm disables all stimulus ports and the ITM
+func (h *StLink) DisableItm() error {
+	return h.WriteRegisters([]RegWrite{
+		{itmLarRegister, itmLarUnlockKey},
+		{itmTerRegister, 0},
+		{itmTcrRegister, 0},
+	})
 }
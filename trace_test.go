@@ -0,0 +1,131 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"testing"
+)
+
+// returns a handle with enabled tracing and data buffered in the st-link
+func newTraceHandle(data []byte) (*StLink, *fakeStLink) {
+	f := newFakeStLink(fakeRamStart, 0x100)
+	f.trace = append([]byte{}, data...)
+
+	h := newFakeHandle(f)
+	h.trace.enabled = true
+
+	return h, f
+}
+
+func TestPollTraceNoData(t *testing.T) {
+	h, f := newTraceHandle(nil)
+
+	buffer := make([]byte, 16)
+	size := uint32(len(buffer))
+
+	if err := h.PollTrace(buffer, &size); err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 0 {
+		t.Errorf("size = %d, want 0", size)
+	}
+
+	if polls := f.commandCount(debugApiV2GetTraceNB); polls != 1 {
+		t.Errorf("%d trace polls, want a single one", polls)
+	}
+}
+
+func TestPollTraceClampsSize(t *testing.T) {
+	h, _ := newTraceHandle([]byte("0123456789"))
+
+	buffer := make([]byte, 4)
+	size := uint32(64)
+
+	if err := h.PollTrace(buffer, &size); err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 4 || !bytes.Equal(buffer, []byte("0123")) {
+		t.Errorf("read %d bytes %q, want 4 bytes \"0123\"", size, buffer)
+	}
+}
+
+func TestTraceClockRestoresRegisters(t *testing.T) {
+	f := newFakeStLink(0xE0000000, 0x10000)
+	h := newFakeHandle(f)
+
+	f.pokeUint32(demcrRegister, demcrVcCoreReset)
+	f.pokeUint32(dwtCtrlRegister, 0x40000000)
+
+	if _, err := h.TraceClock(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if demcr := f.peekUint32(demcrRegister); demcr != demcrVcCoreReset {
+		t.Errorf("DEMCR = 0x%08x after measurement, want 0x%08x", demcr, demcrVcCoreReset)
+	}
+
+	if dwtCtrl := f.peekUint32(dwtCtrlRegister); dwtCtrl != 0x40000000 {
+		t.Errorf("DWT_CTRL = 0x%08x after measurement, want 0x40000000", dwtCtrl)
+	}
+}
+
+func TestMeasureTraceClockSleepingCore(t *testing.T) {
+	f := newFakeStLink(0xE0000000, 0x10000)
+	h := newFakeHandle(f)
+
+	f.pokeUint32(dhcsrRegister, dhcsrSSleep)
+
+	if _, err := h.MeasureTraceClock(); err == nil {
+		t.Fatal("cycle counter measured while the core sleeps")
+	}
+}
+
+func TestReadTraceSizes(t *testing.T) {
+	data := []byte("12345678")
+
+	for _, size := range []int{len(data) - 1, len(data), len(data) + 1} {
+		h, f := newTraceHandle(data)
+
+		read, err := h.ReadTrace(size)
+
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		want := data
+
+		if size < len(data) {
+			want = data[:size]
+		}
+
+		if !bytes.Equal(read, want) {
+			t.Errorf("size %d: read %q, want %q", size, read, want)
+		}
+
+		// never more than buffered is requested from the endpoint
+		if len(f.traceReads) != 1 || f.traceReads[0] != len(want) {
+			t.Errorf("size %d: endpoint reads %v, want [%d]", size, f.traceReads, len(want))
+		}
+	}
+}
+
+func TestReadTraceOverflow(t *testing.T) {
+	for _, available := range []int{traceSize - 1, traceSize, traceSize + 1} {
+		h, _ := newTraceHandle(make([]byte, available))
+
+		read, err := h.ReadTrace(traceSize + 1)
+
+		if len(read) != available {
+			t.Errorf("%d bytes buffered: read %d bytes", available, len(read))
+		}
+
+		if overflow := err == ErrTraceOverflow; overflow != (available >= traceSize) {
+			t.Errorf("%d bytes buffered: err %v", available, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package gostlink
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -15,7 +16,10 @@ type transferCtx struct {
 
 	direction usbTransferDirection
 
-	cmdSize uint32
+	cmdSize  uint32
+	received uint32 // bytes actually received in the data stage
+
+	context context.Context // cancels the usb transfers of the command
 }
 
 func (t *transferCtx) CmdBytes() []byte {
@@ -27,7 +31,12 @@ func (t *transferCtx) DataBytes() []byte {
 }
 
 func (h *StLink) initTransfer(dir usbTransferDirection) *transferCtx {
-	ctx := &transferCtx{cmdSize: 0}
+	return h.initTransferCtx(context.Background(), dir)
+}
+
+// initTransferCtx prepares a transfer which is aborted once parent is done
+func (h *StLink) initTransferCtx(parent context.Context, dir usbTransferDirection) *transferCtx {
+	ctx := &transferCtx{cmdSize: 0, context: parent}
 
 	ctx.cmdBuf = NewBuffer(cmdBufferSize)
 	ctx.dataBuf = NewBuffer(dataBufferSize)
@@ -42,65 +51,141 @@ func (h *StLink) usbTransferErrCheck(ctx *transferCtx, dataLength uint32) error
 	err := h.usbTransferNoErrCheck(ctx, dataLength)
 
 	if err != nil {
-		logger.Error("during usb transfer with error check ", err)
+		logger.WithFields(h.logFields()).WithError(err).Error("usb transfer failed")
 		return err
 	}
 
-	return h.usbErrorCheck(ctx)
+	if h.dryRun {
+		return nil
+	}
+
+	err = h.usbErrorCheck(ctx)
+
+	if err != nil {
+		h.countStats(0, 0, 0, 0, 1)
+	}
+
+	return err
 }
 
 func (h *StLink) usbTransferNoErrCheck(ctx *transferCtx, dataLength uint32) error {
 	ctx.cmdSize = cmdSizeV2
 
-	if h.version.stlink == 1 {
-		return errors.New("st-link V1 api commands not supported")
-	}
-
 	return h.usbTransferReadWrite(ctx, dataLength)
 }
 
+// usbTransferReadWrite always sends the command stage, a data stage is only
+// performed for a dataLength greater than zero (e.g. mode commands). On
+// st-link V1 the command is wrapped for the scsi protocol and the status stage
+// is read after the data stage.
 func (h *StLink) usbTransferReadWrite(ctx *transferCtx, dataLength uint32) error {
+	h.usbMutex.Lock()
+	defer h.usbMutex.Unlock()
+
+	if h.dryRun {
+		h.dryRunTransfer(ctx, dataLength)
+		return nil
+	}
+
+	if h.libUsbDevice == nil && h.transport == nil {
+		return errors.New("st-link handle is closed")
+	}
+
+	if h.disconnected {
+		return ErrProbeDisconnected
+	}
+
+	h.lastTransfer = time.Now()
+
+	h.countStats(1, 0, 0, 0, 0)
+
+	h.waitTransferGap()
 
-	_, err := usbRawWrite(h.txEndpoint, ctx.cmdBuf.Bytes()[:ctx.cmdSize])
+	cmd := ctx.cmdBuf.Bytes()[:ctx.cmdSize]
+
+	if h.version.stlink == 1 {
+		cmd = h.scsiCommandBlock(cmd, ctx.direction, dataLength)
+	}
+
+	_, err := h.usbWrite(ctx.context, cmd)
 
 	if err != nil {
-		return err
+		h.countStats(0, 0, 0, 0, 1)
+		return h.checkDisconnected(err)
 	}
 
 	if ctx.direction == transferOutgoing && dataLength > 0 {
 
-		time.Sleep(time.Millisecond * 10)
+		h.waitTransferGap()
 
-		_, err = usbRawWrite(h.txEndpoint, ctx.dataBuf.Bytes()[:dataLength])
+		bytesWritten, err := h.usbWrite(ctx.context, ctx.dataBuf.Bytes()[:dataLength])
 
 		if err != nil {
-			return err
+			h.countStats(0, 0, 0, 0, 1)
+			return h.checkDisconnected(err)
 		}
 
+		h.countStats(0, 0, uint64(bytesWritten), 0, 0)
+
 	} else if ctx.direction == transferIncoming && dataLength > 0 {
 
 		readBuffer := make([]byte, dataLength)
 
-		_, err = usbRawRead(h.rxEndpoint, readBuffer)
+		h.waitTransferGap()
+
+		bytesRead, err := h.usbRead(ctx.context, readBuffer)
 
 		if err != nil {
-			return err
+			h.countStats(0, 0, 0, 0, 1)
+			return h.checkDisconnected(err)
 		}
 
+		h.countStats(0, uint64(bytesRead), 0, 0, 0)
+
+		ctx.received = uint32(bytesRead)
+
 		ctx.dataBuf.Write(readBuffer)
 	}
 
+	if h.version.stlink == 1 {
+		return h.usbScsiStatus(ctx.context)
+	}
+
 	return nil
 }
 
-func (h *StLink) usbGetReadWriteStatus() error {
+// sleeps until the inter transfer delay passed since the last usb transfer was
+// started, has to be called with usbMutex held
+func (h *StLink) waitTransferGap() {
+	delay := h.config.interTransferDelay
+
+	if delay > 0 {
+		if wait := delay - time.Since(h.lastUsbStage); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		h.lastUsbStage = time.Now()
+	}
+}
+
+// marks the handle dead on ErrProbeDisconnected, further transfers fail fast
+func (h *StLink) checkDisconnected(err error) error {
+	if errors.Is(err, ErrProbeDisconnected) && !h.disconnected {
+		logger.WithFields(h.logFields()).Error("st-link disconnected")
+		h.disconnected = true
+	}
+
+	return err
+}
+
+func (h *StLink) usbGetReadWriteStatus(parent context.Context) error {
 
 	if h.version.jtagApi == jTagApiV1 {
 		logger.Warn("get read write status not supported in jTag api V1")
 		return nil
 	}
 
-	ctx := h.initTransfer(transferIncoming)
+	ctx := h.initTransferCtx(parent, transferIncoming)
 	ctx.cmdBuf.WriteByte(cmdDebug)
 
 	if h.version.flags.Get(flagHasGetLastRwStatus2) {
@@ -7,41 +7,116 @@ package gostlink
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/gousb"
 )
 
+const (
+	usbReadTimeout  = 50 * time.Millisecond
+	usbWriteTimeout = 10 * time.Second
+)
+
+// the libusb context is the only mutable state shared by st-link handles, it is
+// guarded by libUsbMutex. Everything touched by transfers lives in the handle,
+// so handles of different st-links can be used from different goroutines.
 var (
 	libUsbCtx *gousb.Context = nil
+
+	libUsbMutex     sync.Mutex
+	libUsbUsers     int  // number of InitUsb calls not yet balanced by CloseUSB
+	openHandles     int  // number of st-link handles currently open on the context
+	closeUsbPending bool // last user called CloseUSB while handles were still open
 )
 
+// InitUsb initializes the libusb context on first use. Every call has to be
+// balanced by a call to CloseUSB.
 func InitUsb() error {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	closeUsbPending = false
+
 	if libUsbCtx == nil {
 
 		libUsbCtx = gousb.NewContext()
-		libUsbCtx.Debug(3)
 
 		if libUsbCtx != nil {
-			return nil
+			libUsbCtx.Debug(3)
 		} else {
 			return errors.New("could not initialize libusb context")
 		}
-	} else {
-		logger.Warn("libusb context already initialized")
-		return nil
 	}
+
+	libUsbUsers++
+	logger.Tracef("libusb context users: %d", libUsbUsers)
+
+	return nil
 }
 
+// CloseUSB releases one reference on the libusb context. The context is closed
+// by the last reference and, if st-link handles are still open, not before
+// the last of them is closed.
 func CloseUSB() {
-	if libUsbCtx != nil {
-		libUsbCtx.Close()
-	} else {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	if libUsbCtx == nil || libUsbUsers == 0 {
 		logger.Warn("tried to close non initialized libusb context")
+		return
+	}
+
+	libUsbUsers--
+	logger.Tracef("libusb context users: %d", libUsbUsers)
+
+	if libUsbUsers > 0 {
+		return
+	}
+
+	if openHandles > 0 {
+		logger.Debugf("defer closing libusb context until %d open handle(s) are closed", openHandles)
+		closeUsbPending = true
+		return
+	}
+
+	closeUsbContext()
+}
+
+func closeUsbContext() {
+	libUsbCtx.Close()
+	libUsbCtx = nil
+	closeUsbPending = false
+}
+
+func registerHandle() {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	openHandles++
+}
+
+func unregisterHandle() {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	openHandles--
+
+	if openHandles == 0 && closeUsbPending && libUsbCtx != nil {
+		logger.Debug("last handle closed, closing libusb context")
+		closeUsbContext()
 	}
 }
 
 func usbFindDevices(vids []gousb.ID, pids []gousb.ID) ([]*gousb.Device, error) {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	if libUsbCtx == nil {
+		return nil, errors.New("libusb context not initialized, call InitUsb first")
+	}
+
 	devices, err := libUsbCtx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
 		if idExists(vids, desc.Vendor) == true && idExists(pids, desc.Product) == true {
 			logger.Debugf("inspecting usb device [%04x:%04x] on bus %03d:%03d...", uint16(desc.Vendor), uint16(desc.Product), desc.Bus, desc.Address)
@@ -64,18 +139,97 @@ func usbFindDevices(vids []gousb.ID, pids []gousb.ID) ([]*gousb.Device, error) {
 	}
 }
 
-func usbRawWrite(endpoint *gousb.OutEndpoint, buffer []byte) (int, error) {
+// returns the descriptors of all matching devices without opening them
+func usbListDevices(vids []gousb.ID, pids []gousb.ID) ([]gousb.DeviceDesc, error) {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	if libUsbCtx == nil {
+		return nil, errors.New("libusb context not initialized, call InitUsb first")
+	}
 
-	opCtx := context.Background()
+	var descs []gousb.DeviceDesc
+
+	_, err := libUsbCtx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if idExists(vids, desc.Vendor) && idExists(pids, desc.Product) {
+			descs = append(descs, *desc)
+		}
+
+		return false
+	})
 
-	var done func()
-	opCtx, done = context.WithTimeout(opCtx, time.Millisecond*10000)
+	return descs, err
+}
+
+// opens the device at the bus address of desc
+func usbOpenDevice(desc gousb.DeviceDesc) (*gousb.Device, error) {
+	libUsbMutex.Lock()
+	defer libUsbMutex.Unlock()
+
+	if libUsbCtx == nil {
+		return nil, errors.New("libusb context not initialized, call InitUsb first")
+	}
+
+	devices, err := libUsbCtx.OpenDevices(func(d *gousb.DeviceDesc) bool {
+		return d.Bus == desc.Bus && d.Address == desc.Address
+	})
+
+	if len(devices) == 0 {
+		if err == nil {
+			err = fmt.Errorf("usb device %03d:%03d disappeared", desc.Bus, desc.Address)
+		}
+
+		return nil, err
+	}
+
+	for _, dev := range devices[1:] {
+		dev.Close()
+	}
+
+	return devices[0], nil
+}
+
+// usbTransport carries the usb transfers of a handle instead of its libusb
+// endpoints, the tests use it to emulate a st-link
+type usbTransport interface {
+	write(parent context.Context, buffer []byte) (int, error)
+	read(parent context.Context, buffer []byte) (int, error)
+	readTrace(parent context.Context, buffer []byte, timeout time.Duration) (int, error)
+}
+
+func (h *StLink) usbWrite(parent context.Context, buffer []byte) (int, error) {
+	if h.transport != nil {
+		return h.transport.write(parent, buffer)
+	}
+
+	return usbRawWrite(parent, h.txEndpoint, buffer)
+}
+
+func (h *StLink) usbRead(parent context.Context, buffer []byte) (int, error) {
+	if h.transport != nil {
+		return h.transport.read(parent, buffer)
+	}
+
+	return usbRawRead(parent, h.rxEndpoint, buffer)
+}
+
+func (h *StLink) usbReadTraceEndpoint(parent context.Context, buffer []byte, timeout time.Duration) (int, error) {
+	if h.transport != nil {
+		return h.transport.readTrace(parent, buffer, timeout)
+	}
+
+	return usbRawReadTimeout(parent, h.traceEndpoint, buffer, timeout)
+}
+
+// the transfer is aborted when parent is cancelled or after the write timeout
+func usbRawWrite(parent context.Context, endpoint *gousb.OutEndpoint, buffer []byte) (int, error) {
+	opCtx, done := context.WithTimeout(parent, usbWriteTimeout)
 	defer done()
 
 	bytesWritten, err := endpoint.WriteContext(opCtx, buffer)
 
 	if err != nil {
-		return -1, err
+		return -1, usbRawError(err)
 	} else {
 		logger.Tracef("%d Bytes -> EP-%d", bytesWritten, endpoint.Desc.Number)
 		return bytesWritten, nil
@@ -83,23 +237,33 @@ func usbRawWrite(endpoint *gousb.OutEndpoint, buffer []byte) (int, error) {
 
 }
 
-func usbRawRead(endpoint *gousb.InEndpoint, buffer []byte) (int, error) {
-	opCtx := context.Background()
+func usbRawRead(parent context.Context, endpoint *gousb.InEndpoint, buffer []byte) (int, error) {
+	return usbRawReadTimeout(parent, endpoint, buffer, usbReadTimeout)
+}
 
-	var done func()
-	opCtx, done = context.WithTimeout(opCtx, time.Millisecond*50)
+func usbRawReadTimeout(parent context.Context, endpoint *gousb.InEndpoint, buffer []byte, timeout time.Duration) (int, error) {
+	opCtx, done := context.WithTimeout(parent, timeout)
 	defer done()
 
 	bytesRead, err := endpoint.ReadContext(opCtx, buffer)
 
 	if err != nil {
-		return -1, err
+		return -1, usbRawError(err)
 	} else {
 		logger.Tracef("EP-%d -> %d Bytes", endpoint.Desc.Number, bytesRead)
 		return bytesRead, nil
 	}
 }
 
+// maps the errors of a removed device to ErrProbeDisconnected
+func usbRawError(err error) error {
+	if errors.Is(err, gousb.ErrorNoDevice) || errors.Is(err, gousb.TransferNoDevice) {
+		return ErrProbeDisconnected
+	}
+
+	return err
+}
+
 func (h *StLink) maxBlockSize(tarAutoIncrBlock uint32, address uint32) uint32 {
 	var maxTarBlock = tarAutoIncrBlock - ((tarAutoIncrBlock - 1) & address)
 
@@ -20,33 +20,35 @@ func idExists(slice []gousb.ID, item gousb.ID) bool {
 	return false
 }
 
-func memset(a []uint8, size int, v uint8) {
-	for i := 0; i < size; i++ {
-		a[i] = v
+// Adds an uint32 to current buffer
+// it's also possible to determine bit position in buffer and amount of bits to be set.
+// Every field is set at its absolute bit position (counted from the start of the
+// buffer), the buffer grows if needed.
+func addU32ToBuffer(buffer *bytes.Buffer, firstBit uint, numBits uint, value uint32) {
+	if numBits > 32 {
+		numBits = 32
 	}
-}
 
-// Adds an uint32 to current buffer
-// it's also possible to determine bit position in buffer and amount of bits to be set
+	for uint(buffer.Len()) < (firstBit+numBits+7)/8 {
+		buffer.WriteByte(0)
+	}
 
-func addU32ToBuffer(buffer *bytes.Buffer, firstBit uint, numBits uint, value uint32) {
+	data := buffer.Bytes()
 
 	if (numBits == 32) && (firstBit == 0) {
-		buffer.WriteByte(uint8((value >> 0) & 0xff))
-		buffer.WriteByte(uint8((value >> 8) & 0xff))
-		buffer.WriteByte(uint8((value >> 16) & 0xff))
-		buffer.WriteByte(uint8((value >> 24) & 0xff))
+		data[0] = uint8((value >> 0) & 0xff)
+		data[1] = uint8((value >> 8) & 0xff)
+		data[2] = uint8((value >> 16) & 0xff)
+		data[3] = uint8((value >> 24) & 0xff)
 
 	} else {
-		logger.Panic("Implement bit position setting in addU32ToBuffer")
-		/*
-			for i := firstBit; i < firstBit+numBits; i++ {
-				if ((value >> (i - firstBit)) & 1) == 1 {
-					buffer[i/8] |= 1 << (i % 8)
-				} else {
-					buffer[i/8] &= ^(1 << (i % 8))
-				}
-			}*/
+		for i := firstBit; i < firstBit+numBits; i++ {
+			if ((value >> (i - firstBit)) & 1) == 1 {
+				data[i/8] |= 1 << (i % 8)
+			} else {
+				data[i/8] &= ^(1 << (i % 8))
+			}
+		}
 	}
 }
 
@@ -0,0 +1,64 @@
+// Copyright 2020 Sebastian Lehmann. All rights reserved.
+// Use of this source code is governed by a GNU-style
+// license that can be found in the LICENSE file.
+
+package gostlink
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddU32ToBufferFields(t *testing.T) {
+	var buffer bytes.Buffer
+
+	// 3 bit, 5 bit, a 12 bit field crossing two byte boundaries and a single bit
+	addU32ToBuffer(&buffer, 0, 3, 0x5)
+	addU32ToBuffer(&buffer, 3, 5, 0x1b)
+	addU32ToBuffer(&buffer, 8, 12, 0xabc)
+	addU32ToBuffer(&buffer, 20, 1, 1)
+
+	if want := []byte{0xdd, 0xbc, 0x1a}; !bytes.Equal(buffer.Bytes(), want) {
+		t.Fatalf("buffer = % x, want % x", buffer.Bytes(), want)
+	}
+
+	fields := []struct{ first, num, value uint }{{0, 3, 0x5}, {3, 5, 0x1b}, {8, 12, 0xabc}, {20, 1, 1}}
+
+	for _, f := range fields {
+		if value := buf_get_u32(buffer.Bytes(), f.first, f.num); value != uint32(f.value) {
+			t.Errorf("field at bit %d = 0x%x, want 0x%x", f.first, value, f.value)
+		}
+	}
+}
+
+func TestAddU32ToBufferOverwrite(t *testing.T) {
+	buffer := bytes.NewBuffer([]byte{0xff, 0xff})
+
+	addU32ToBuffer(buffer, 4, 8, 0)
+
+	if want := []byte{0x0f, 0xf0}; !bytes.Equal(buffer.Bytes(), want) {
+		t.Errorf("buffer = % x, want % x", buffer.Bytes(), want)
+	}
+}
+
+// a full word at bit 0 is placed like every other field, not appended
+func TestAddU32ToBufferWord(t *testing.T) {
+	var buffer bytes.Buffer
+
+	addU32ToBuffer(&buffer, 32, 8, 0x55)
+	addU32ToBuffer(&buffer, 0, 32, 0x12345678)
+
+	if want := []byte{0x78, 0x56, 0x34, 0x12, 0x55}; !bytes.Equal(buffer.Bytes(), want) {
+		t.Fatalf("buffer = % x, want % x", buffer.Bytes(), want)
+	}
+
+	if value := buf_get_u32(buffer.Bytes(), 0, 32); value != 0x12345678 {
+		t.Errorf("word = 0x%08x", value)
+	}
+
+	addU32ToBuffer(&buffer, 0, 32, 0xcafe)
+
+	if buffer.Len() != 5 || buf_get_u32(buffer.Bytes(), 0, 32) != 0xcafe {
+		t.Errorf("buffer = % x after overwriting the word", buffer.Bytes())
+	}
+}
@@ -5,12 +5,20 @@
 package gostlink
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/boljen/go-bitmap"
 	"github.com/google/gousb"
 )
 
+// RefreshVersion reads the firmware version again and updates the feature
+// flags. The version is read when opening the st-link, this is only needed if
+// the firmware was changed while the handle was open.
+func (h *StLink) RefreshVersion() error {
+	return h.useParseVersion()
+}
+
 func (h *StLink) useParseVersion() error {
 	var v, x, y, jtag, swim, msd, bridge byte = 0, 0, 0, 0, 0, 0, 0
 
@@ -24,14 +32,25 @@ func (h *StLink) useParseVersion() error {
 		return err
 	}
 
-	version := ctx.dataBuf.ReadUint16BE()
+	version, err := tryConvertToUint16(ctx.DataBytes(), bigEndian)
+
+	if err != nil {
+		return err
+	}
 
 	v = byte((version >> 12) & 0x0f)
 	x = byte((version >> 6) & 0x3f)
 	y = byte(version & 0x3f)
 
-	h.vid = gousb.ID(convertToUint16(ctx.DataBytes()[2:], littleEndian))
-	h.pid = gousb.ID(convertToUint16(ctx.DataBytes()[4:], littleEndian))
+	vid, errVid := tryConvertToUint16(ctx.DataBytes()[2:], littleEndian)
+	pid, errPid := tryConvertToUint16(ctx.DataBytes()[4:], littleEndian)
+
+	if errVid != nil || errPid != nil {
+		return errors.New("truncated version response from st-link")
+	}
+
+	h.vid = gousb.ID(vid)
+	h.pid = gousb.ID(pid)
 
 	switch h.pid {
 	case stLinkV21Pid, stLinkV21NoMsdPid:
@@ -63,6 +82,12 @@ func (h *StLink) useParseVersion() error {
 			return err
 		}
 
+		if len(ctxV3.DataBytes()) < 12 {
+			return errors.New("truncated extended version response from st-link")
+		}
+
+		h.version.versionEx = append([]byte{}, ctxV3.DataBytes()[:12]...)
+
 		v = ctxV3.DataBytes()[0]
 		swim = ctxV3.DataBytes()[1]
 		jtag = ctxV3.DataBytes()[2]
@@ -134,8 +159,10 @@ func (h *StLink) useParseVersion() error {
 		}
 
 		/* Banked regs (DPv1 & DPv2) support from V2J32 */
+		/* API to read/write memory on any access port from V2J32 */
 		if h.version.jtag >= 32 {
 			flags.Set(flagHasDpBankSel, true)
+			flags.Set(flagHasCsw, true)
 		}
 	case 3:
 		/* all STLINK-V3 use api-v3 */
@@ -151,6 +178,7 @@ func (h *StLink) useParseVersion() error {
 
 		if h.version.jtag >= 2 {
 			flags.Set(flagHasDpBankSel, true) // Banked regs (DPv1 & DPv2) support from V3J2
+			flags.Set(flagHasCsw, true)       // API to read/write memory on any access port from V3J2
 		}
 
 		if h.version.jtag >= 6 {
@@ -177,9 +205,36 @@ func (h *StLink) useParseVersion() error {
 		vStr += fmt.Sprintf("B%d", bridge)
 	}
 
-	serialNo, _ := h.libUsbDevice.SerialNumber()
+	if swim > 0 {
+		vStr += fmt.Sprintf("S%d", swim)
+	}
+
+	h.version.str = vStr
+	h.serialNo, _ = h.libUsbDevice.SerialNumber()
 
-	logger.Debugf("parsed st-link version [%s] for [%s]", vStr, serialNo)
+	logger.Debugf("parsed st-link version [%s] for [%s]", vStr, h.serialNo)
 
 	return nil
 }
+
+// FirmwareDetails returns the firmware version string of the st-link together
+// with the usb device release and, for STLINK-V3, the raw extended version
+// response. The firmware reports no build or commit string.
+func (h *StLink) FirmwareDetails() (string, error) {
+	if h.version.str == "" {
+		return "", errors.New("st-link version not read")
+	}
+
+	details := h.version.str
+
+	if h.libUsbDevice != nil && h.libUsbDevice.Desc != nil {
+		release := uint16(h.libUsbDevice.Desc.Device)
+		details += fmt.Sprintf(", usb release %x.%02x", release>>8, release&0xff)
+	}
+
+	if h.version.versionEx != nil {
+		details += fmt.Sprintf(", version ex % x", h.version.versionEx)
+	}
+
+	return details, nil
+}